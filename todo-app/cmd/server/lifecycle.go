@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// closerFunc implements io.Closer with a function
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// listen returns the socket passed by systemd socket activation, if any, or
+// listens on the address
+func listen(addr string) (net.Listener, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		if fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); fds > 0 {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+
+			// passed file descriptors start at 3, only the first is used
+			file := os.NewFile(3, "systemd-socket")
+			defer file.Close()
+			slog.Info("using socket from systemd socket activation")
+			return net.FileListener(file)
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serve runs the server on the listener until SIGINT or SIGTERM. Then it stops
+// accepting connections, waits up to the timeout for in-flight requests to finish
+// and closes all closers, like persistence backends.
+func serve(server *http.Server, listener net.Listener, timeout time.Duration, closers ...io.Closer) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	return serveUntil(server, listener, signals, timeout, closers...)
+}
+
+// serveUntil runs the server on the listener until a signal is received, then
+// shuts down like serve
+func serveUntil(server *http.Server, listener net.Listener, signals <-chan os.Signal, timeout time.Duration, closers ...io.Closer) error {
+	errs := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			errs <- server.ServeTLS(listener, "", "")
+		} else {
+			errs <- server.Serve(listener)
+		}
+	}()
+	notifySystemd("READY=1")
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		slog.Info("shutting down", "signal", sig.String(), "timeout", timeout)
+	}
+	notifySystemd("STOPPING=1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("requests still in-flight, closing connections", "timeout", timeout)
+		server.Close()
+	}
+
+	for _, closer := range closers {
+		if closeErr := closer.Close(); closeErr != nil {
+			slog.Error("close failed", "error", closeErr)
+			if err == nil {
+				err = closeErr
+			}
+		}
+	}
+
+	if err == nil {
+		slog.Info("shutdown complete")
+	}
+	return err
+}
+
+// notifySystemd sends a state to the systemd notification socket, if started by
+// systemd with Type=notify
+func notifySystemd(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		// abstract namespace socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("notify systemd failed", "state", state, "error", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		slog.Warn("notify systemd failed", "state", state, "error", err)
+	}
+}
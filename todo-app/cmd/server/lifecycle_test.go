@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeUntil_Drain(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(entered)
+		<-release
+		rw.Write([]byte("done"))
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := "http://" + listener.Addr().String()
+
+	closed := false
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serveUntil(server, listener, signals, time.Second, closerFunc(func() error {
+			closed = true
+			return nil
+		}))
+	}()
+
+	// a request is in-flight while the signal arrives
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		res, err := http.Get(addr)
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		responses <- response{body: string(body), err: err}
+	}()
+	<-entered
+	signals <- syscall.SIGTERM
+
+	// no new connections are accepted
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, closed, "closers wait for in-flight requests")
+
+	// the in-flight request is answered
+	close(release)
+	res := <-responses
+	require.NoError(t, res.err)
+	assert.Equal(t, "done", res.body)
+	assert.NoError(t, <-served)
+	assert.True(t, closed)
+}
+
+func TestServeUntil_Timeout(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	server := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(entered)
+		<-release
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	closeErr := errors.New("close failed")
+	var closed []string
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- serveUntil(server, listener, signals, 50*time.Millisecond,
+			closerFunc(func() error {
+				closed = append(closed, "first")
+				return closeErr
+			}),
+			closerFunc(func() error {
+				closed = append(closed, "second")
+				return nil
+			}),
+		)
+	}()
+
+	go http.Get("http://" + listener.Addr().String())
+	<-entered
+	signals <- syscall.SIGINT
+
+	// requests exceeding the timeout don't keep the closers from running
+	select {
+	case err = <-served:
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "expected deadline, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not end after timeout")
+	}
+	assert.Equal(t, []string{"first", "second"}, closed)
+}
+
+func TestServeUntil_CloseError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	closeErr := errors.New("close failed")
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGTERM
+	err = serveUntil(&http.Server{Handler: http.NotFoundHandler()}, listener, signals, time.Second,
+		closerFunc(func() error { return closeErr }))
+	assert.Equal(t, closeErr, err)
+}
+
+func TestListen(t *testing.T) {
+	os.Unsetenv("LISTEN_PID")
+	listener, err := listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	assert.Equal(t, "tcp", listener.Addr().Network())
+}
+
+func TestNotifySystemd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	notifySystemd("READY=1")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	state := make([]byte, 64)
+	n, err := conn.Read(state)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1", string(state[:n]))
+}
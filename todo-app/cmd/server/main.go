@@ -1,19 +1,37 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	todo "github.com/ukautz/go-intro/todo-app/pkg"
+	"github.com/ukautz/go-intro/todo-app/pkg/client"
 	"github.com/urfave/cli/v2"
 )
 
+// build information, injected at link time (see Makefile)
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "server"
 	app.Usage = "HTTP API for todos"
+	app.Version = version
 
 	app.Flags = []cli.Flag{
 		&cli.StringFlag{
@@ -22,18 +40,224 @@ func main() {
 			Usage:   "Path to directory to store todos",
 			Value:   filepath.Join("data", "store"),
 		},
+		&cli.StringFlag{
+			Name:  "storage-remote-url",
+			Usage: "URL of another todo server including route prefix, used as storage instead of the directory",
+		},
+		&cli.StringFlag{
+			Name:  "storage-remote-user",
+			Usage: "User name for the remote storage server",
+		},
+		&cli.StringFlag{
+			Name:  "storage-remote-password",
+			Usage: "Password for the remote storage server",
+		},
+		&cli.StringFlag{
+			Name:  "storage-remote-api-key",
+			Usage: "API key for the remote storage server",
+		},
+		&cli.BoolFlag{
+			Name:  "storage-remote-forward-credentials",
+			Usage: "Forward the credentials of requests to the remote storage server, instead of using its own",
+		},
 		&cli.StringFlag{
 			Name:    "users",
 			Aliases: []string{"u"},
 			Usage:   "Path to JSON file containing user credentials",
 			Value:   filepath.Join("data", "users.json"),
 		},
+		&cli.DurationFlag{
+			Name:  "users-reload-interval",
+			Usage: "Interval for checking the users file for changes, 0 disables (send SIGHUP to reload)",
+			Value: 5 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:  "api-keys",
+			Usage: "Path to JSON file storing API keys of users",
+			Value: filepath.Join("data", "apikeys.json"),
+		},
+		&cli.StringFlag{
+			Name:  "oidc-issuer",
+			Usage: "URL of an OpenID Connect provider, whose tokens are accepted",
+		},
+		&cli.StringFlag{
+			Name:  "oidc-audience",
+			Usage: "Audience required in OpenID Connect tokens, usually the client ID of the API (required with oidc-issuer)",
+		},
+		&cli.StringFlag{
+			Name:  "oidc-user-claim",
+			Usage: "Claim of OpenID Connect tokens which is matched against user names",
+			Value: "sub",
+		},
+		&cli.BoolFlag{
+			Name:  "oidc-auto-provision",
+			Usage: "Accept OpenID Connect tokens of users which are not in the users file",
+		},
+		&cli.StringFlag{
+			Name:  "sessions-directory",
+			Usage: "Path to directory to store sessions of browser clients, kept in memory if empty",
+		},
+		&cli.DurationFlag{
+			Name:  "session-idle-timeout",
+			Usage: "Sessions end when not used for this long",
+			Value: 30 * time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:  "session-absolute-timeout",
+			Usage: "Sessions end this long after login",
+			Value: 12 * time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:  "insecure-cookies",
+			Usage: "Send session cookies over plain HTTP, for development only",
+		},
+		&cli.IntFlag{
+			Name:  "lockout-user-failures",
+			Usage: "Failed logins after which a user name is locked out",
+			Value: 5,
+		},
+		&cli.IntFlag{
+			Name:  "lockout-ip-failures",
+			Usage: "Failed logins after which a client IP is locked out",
+			Value: 20,
+		},
+		&cli.DurationFlag{
+			Name:  "lockout-duration",
+			Usage: "How long user names and client IPs are locked out",
+			Value: 15 * time.Minute,
+		},
+		&cli.Int64Flag{
+			Name:  "max-body-size",
+			Usage: "Maximum size of request bodies in bytes",
+			Value: todo.DefaultMaxBodySize,
+		},
+		&cli.StringFlag{
+			Name:  "rate-limit-ip",
+			Usage: "Requests per second and burst per client IP, like 50:100, empty disables",
+			Value: "50:100",
+		},
+		&cli.StringFlag{
+			Name:  "rate-limit-user",
+			Usage: "Requests per second and burst per user, like 20:40, empty disables",
+			Value: "20:40",
+		},
+		&cli.StringSliceFlag{
+			Name:  "rate-limit-route",
+			Usage: "Requests per second and burst per user for a route, like \"POST /todo=1:5\" or \"/todo/{id}=5:10\"",
+		},
+		&cli.StringFlag{
+			Name:  "cors-config",
+			Usage: "Path to JSON file with the CORS configuration, which flags override",
+		},
+		&cli.StringSliceFlag{
+			Name:  "cors-origin",
+			Usage: "Origin of browser front-ends allowed to call the API, like https://*.example.com, enables CORS",
+		},
+		&cli.StringSliceFlag{
+			Name:  "cors-method",
+			Usage: "Method front-ends may use (default: GET, POST, PUT, DELETE)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "cors-header",
+			Usage: "Request header front-ends may send (default: headers of the API)",
+		},
+		&cli.BoolFlag{
+			Name:  "cors-credentials",
+			Usage: "Allow front-ends to send cookies and authorization headers",
+		},
+		&cli.DurationFlag{
+			Name:  "cors-max-age",
+			Usage: "How long browsers may cache preflight responses",
+			Value: 10 * time.Minute,
+		},
+		&cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "Path to PEM certificate file, enables HTTPS together with --tls-key",
+		},
+		&cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "Path to PEM private key file of the certificate",
+		},
+		&cli.StringFlag{
+			Name:  "tls-redirect-address",
+			Usage: "Listen address for a plain HTTP server redirecting to HTTPS, like :80",
+		},
+		&cli.StringFlag{
+			Name:  "tls-client-ca",
+			Usage: "Path to PEM file of CA certificates, which issue client certificates",
+		},
+		&cli.StringFlag{
+			Name:  "tls-client-crl",
+			Usage: "Path to certificate revocation list of the client CA",
+		},
+		&cli.StringFlag{
+			Name:  "client-cert-users",
+			Usage: "Path to JSON file mapping client certificate names to user IDs",
+		},
 		&cli.StringFlag{
 			Name:    "address",
 			Aliases: []string{"a"},
 			Usage:   "Listen address for the HTTP server",
 			Value:   "127.0.0.1:12345",
 		},
+		&cli.DurationFlag{
+			Name:  "read-timeout",
+			Usage: "Maximum duration for reading a request, including the body",
+			Value: time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:  "read-header-timeout",
+			Usage: "Maximum duration for reading request headers",
+			Value: 10 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:  "write-timeout",
+			Usage: "Maximum duration for writing a response",
+			Value: time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:  "idle-timeout",
+			Usage: "Maximum duration to keep idle keep-alive connections open",
+			Value: 2 * time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:  "shutdown-timeout",
+			Usage: "Maximum duration to wait for in-flight requests on shutdown",
+			Value: 30 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:  "metrics-address",
+			Usage: "Listen address for serving /metrics, like localhost:9100. Not served if empty, unless --metrics-public",
+		},
+		&cli.BoolFlag{
+			Name:  "metrics-public",
+			Usage: "Serve /metrics on the API address, without authentication",
+		},
+		&cli.StringFlag{
+			Name:  "log-level",
+			Usage: "Minimum level of log messages: debug, info, warn or error",
+			Value: "info",
+		},
+		&cli.StringFlag{
+			Name:  "log-format",
+			Usage: "Format of log messages: json or text",
+			Value: todo.LogFormatJSON,
+		},
+		&cli.StringFlag{
+			Name:  "trace-exporter",
+			Usage: "Where to send traces: none, stdout or otlp",
+			Value: "none",
+		},
+		&cli.StringFlag{
+			Name:  "trace-otlp-endpoint",
+			Usage: "URL of the OTLP/HTTP traces endpoint of an OpenTelemetry collector",
+			Value: "http://localhost:4318/v1/traces",
+		},
+		&cli.StringFlag{
+			Name:  "trace-service-name",
+			Usage: "Name of this server in traces",
+			Value: "todo-server",
+		},
 		&cli.StringFlag{
 			Name:    "path-prefix",
 			Aliases: []string{"p"},
@@ -46,27 +270,325 @@ func main() {
 		listenAddr := c.String("address")
 		routePrefix := c.String("path-prefix")
 
-		// init storage
-		store := todo.DirectoryPersistence(c.String("storage-directory"))
+		// structured logs, including those of the standard library log
+		level, err := todo.ParseLogLevel(c.String("log-level"))
+		if err != nil {
+			return err
+		}
+		logger, err := todo.NewLogger(os.Stderr, level, c.String("log-format"))
+		if err != nil {
+			return err
+		}
+		log.SetFlags(0)
+		slog.SetDefault(logger)
+
+		// init storage, a local directory or another server
+		var store todo.Persistence
+		var storageCheck func() error
+		storage := c.String("storage-directory")
+		if remoteURL := c.String("storage-remote-url"); remoteURL != "" {
+			remote := client.NewRemotePersistence(remoteURL)
+			remote.Client.Username = c.String("storage-remote-user")
+			remote.Client.Password = c.String("storage-remote-password")
+			remote.Client.APIKey = c.String("storage-remote-api-key")
+			remote.Client.UserAgent = "todo-server/" + version
+			store, storageCheck, storage = remote, remote.Reachable, remoteURL
+		} else {
+			directory := todo.DirectoryPersistence(storage)
+			store, storageCheck = directory, directory.Writable
+		}
+		metrics := todo.NewMetrics()
 
-		// load users for authentication
+		// load users for authentication, reload them on changes and SIGHUP
 		usersFile := c.String("users")
-		auth, err := todo.LoadAuthenticationFromJSON(usersFile)
+		users, err := todo.NewJSONFileUserStore(usersFile)
 		if err != nil {
 			return err
 		}
+		stopWatching := make(chan struct{})
+		if interval := c.Duration("users-reload-interval"); interval > 0 {
+			go users.Watch(interval, stopWatching)
+		}
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+		go func() {
+			for range hangup {
+				if err := users.Reload(); err != nil {
+					slog.Warn("reload users failed, keeping previous", "file", usersFile, "error", err)
+				}
+			}
+		}()
+
+		// load API keys of users
+		apiKeys, err := todo.NewFileAPIKeyStore(c.String("api-keys"))
+		if err != nil {
+			return err
+		}
+
+		// humans use basic auth, machines use API keys
+		auth := todo.ChainAuthentication{
+			todo.StoreAuthentication{Store: users},
+			todo.APIKeyAuthentication{Store: apiKeys},
+		}
+
+		// optionally accept tokens of a single sign on provider
+		if issuer := c.String("oidc-issuer"); issuer != "" {
+
+			// without audience, tokens the issuer made for any of it's clients are accepted
+			if c.String("oidc-audience") == "" {
+				return errors.New("--oidc-issuer requires --oidc-audience")
+			}
+			oidc := todo.NewOIDCAuthentication(issuer, c.String("oidc-audience"))
+			oidc.UserClaim = c.String("oidc-user-claim")
+			oidc.AutoProvision = c.Bool("oidc-auto-provision")
+			oidc.Users = users
+			auth = append(auth, oidc)
+		}
+
+		// HTTPS with certificates which are reloaded after renewal
+		var tlsConfig *tls.Config
+		if certFile, keyFile := c.String("tls-cert"), c.String("tls-key"); certFile != "" {
+			reloader, err := todo.NewCertificateReloader(certFile, keyFile)
+			if err != nil {
+				return err
+			}
+			tlsConfig = todo.NewTLSConfig(reloader.GetCertificate)
+		}
+
+		// services authenticate with client certificates
+		if caFile := c.String("tls-client-ca"); caFile != "" && tlsConfig == nil {
+			return errors.New("client certificates require --tls-cert and --tls-key")
+		} else if caFile != "" {
+			cas, err := todo.LoadCertificatesFromPEM(caFile)
+			if err != nil {
+				return err
+			}
+			tlsConfig.ClientCAs = x509.NewCertPool()
+			for _, ca := range cas {
+				tlsConfig.ClientCAs.AddCert(ca)
+			}
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+			clientCerts := todo.ClientCertAuthentication{}
+			if clientCerts.Mapping, err = todo.LoadClientCertMappingFromJSON(c.String("client-cert-users")); err != nil {
+				return err
+			}
+			if crlFile := c.String("tls-client-crl"); crlFile != "" {
+				if clientCerts.CRL, err = todo.LoadCRL(crlFile, cas); err != nil {
+					return err
+				}
+			}
+			auth = append(auth, clientCerts)
+		}
+
+		// browser clients login for a session cookie
+		var sessionStore todo.SessionStore = todo.NewMemorySessionStore()
+		if dir := c.String("sessions-directory"); dir != "" {
+			sessionStore = todo.FileSessionStore(dir)
+		}
+		sessions := todo.NewSessionAuthentication(sessionStore)
+		sessions.IdleTimeout = c.Duration("session-idle-timeout")
+		sessions.AbsoluteTimeout = c.Duration("session-absolute-timeout")
+		sessions.Insecure = c.Bool("insecure-cookies")
+		go sessions.Sweep(time.Minute, stopWatching)
+		auth = append(auth, sessions)
+
+		// slow down guessing of credentials
+		lockouts := todo.NewMemoryAttemptTracker(24 * time.Hour)
+		throttled := todo.NewThrottledAuthentication(auth, lockouts)
+		throttled.MaxUserFailures = c.Int("lockout-user-failures")
+		throttled.MaxIPFailures = c.Int("lockout-ip-failures")
+		throttled.Lockout = c.Duration("lockout-duration")
+
+		// keep single clients from saturating the server
+		rateLimiter := &todo.RateLimiter{
+			Store:  todo.NewMemoryRateLimitStore(),
+			Routes: make(map[string]todo.RateLimit),
+		}
+		if spec := c.String("rate-limit-ip"); spec != "" {
+			if rateLimiter.IP, err = todo.ParseRateLimit(spec); err != nil {
+				return err
+			}
+		}
+		if spec := c.String("rate-limit-user"); spec != "" {
+			if rateLimiter.User, err = todo.ParseRateLimit(spec); err != nil {
+				return err
+			}
+		}
+		for _, spec := range c.StringSlice("rate-limit-route") {
+			route, limit, err := todo.ParseRouteRateLimit(spec)
+			if err != nil {
+				return err
+			}
+			rateLimiter.Routes[route] = limit
+		}
+
+		// probes of orchestrators
+		health := &todo.Health{
+			Checks: map[string]func() error{
+				"storage": storageCheck,
+				"users": func() error {
+					if list, err := users.List(); err != nil {
+						return err
+					} else if len(list) == 0 {
+						return errors.New("no users loaded")
+					}
+					return nil
+				},
+			},
+			Build: todo.BuildInfo{Version: version, Commit: commit, Date: date},
+		}
 
 		// setup router
 		router := todo.Router{
 			Prefix:         routePrefix,
-			Authentication: auth,
-			Persistence:    store,
+			Authentication: todo.InstrumentedAuthentication{Authentication: throttled, Metrics: metrics},
+			Persistence:    todo.InstrumentedPersistence{Persistence: store, Metrics: metrics},
+			APIKeys:        apiKeys,
+			Users:          users,
+			Lockouts:       lockouts,
+			Throttle:       &throttled,
+			Sessions:       sessions,
+			Health:         health,
+			RateLimiter:    rateLimiter,
+			MaxBodySize:    c.Int64("max-body-size"),
+		}
+		if c.Bool("storage-remote-forward-credentials") {
+			router.Middleware = append(router.Middleware, client.ForwardCredentials)
+		}
+
+		// browser front-ends on other origins, preflights are answered after rate
+		// limiting per client IP and before authentication
+		cors := todo.NewCORS()
+		if file := c.String("cors-config"); file != "" {
+			if cors, err = todo.LoadCORSFromJSON(file); err != nil {
+				return err
+			}
+		}
+		if c.IsSet("cors-origin") {
+			cors.AllowedOrigins = c.StringSlice("cors-origin")
+		}
+		if c.IsSet("cors-method") {
+			cors.AllowedMethods = c.StringSlice("cors-method")
+		}
+		if c.IsSet("cors-header") {
+			cors.AllowedHeaders = c.StringSlice("cors-header")
+		}
+		if c.IsSet("cors-credentials") {
+			cors.AllowCredentials = c.Bool("cors-credentials")
+		}
+		if c.IsSet("cors-max-age") || c.String("cors-config") == "" {
+			cors.MaxAge = int(c.Duration("cors-max-age").Seconds())
+		}
+		if len(cors.AllowedOrigins) > 0 {
+			if err = cors.Validate(); err != nil {
+				return err
+			}
+			router.Middleware = append(router.Middleware, cors.Middleware)
+		}
+		api := router.Handler()
+
+		// count requests by route, metrics are scraped on their own address or,
+		// only if explicitly enabled, on the API address
+		handler := metrics.InstrumentHandler(api, api.Route)
+		metricsAddr := c.String("metrics-address")
+		if c.Bool("metrics-public") {
+			instrumented := handler
+			handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if req.URL.Path == "/metrics" {
+					metrics.ServeHTTP(rw, req)
+				} else {
+					instrumented.ServeHTTP(rw, req)
+				}
+			})
 		}
 
-		// run server
-		log.Printf("Starting API server at http://%s%s, storage directory: %s",
-			listenAddr, routePrefix, store)
-		return http.ListenAndServe(listenAddr, router)
+		// trace requests, continuing traces of clients
+		handler = todo.LogRequests(logger)(handler)
+		var tracer *todo.Tracer
+		switch exporter := c.String("trace-exporter"); exporter {
+		case "none":
+		case "stdout":
+			tracer = todo.NewTracer(todo.StdoutExporter{Out: os.Stdout}, 5*time.Second)
+		case "otlp":
+			tracer = todo.NewTracer(todo.OTLPExporter{
+				Endpoint:    c.String("trace-otlp-endpoint"),
+				ServiceName: c.String("trace-service-name"),
+			}, 5*time.Second)
+		default:
+			return fmt.Errorf("unknown trace exporter %q", exporter)
+		}
+		if tracer != nil {
+			handler = tracer.Middleware(handler, api.Route)
+		}
+
+		// run server until stopped
+		server := &http.Server{
+			Addr:              listenAddr,
+			Handler:           handler,
+			ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+			TLSConfig:         tlsConfig,
+			ReadTimeout:       c.Duration("read-timeout"),
+			ReadHeaderTimeout: c.Duration("read-header-timeout"),
+			WriteTimeout:      c.Duration("write-timeout"),
+			IdleTimeout:       c.Duration("idle-timeout"),
+		}
+		listener, err := listen(listenAddr)
+		if err != nil {
+			return err
+		}
+		closers := []io.Closer{
+			closerFunc(func() error {
+				close(stopWatching)
+				return nil
+			}),
+		}
+		if closer, ok := store.(io.Closer); ok {
+			closers = append(closers, closer)
+		}
+		if tracer != nil {
+			closers = append(closers, tracer)
+		}
+
+		if metricsAddr != "" {
+			metricsServer := &http.Server{
+				Addr:              metricsAddr,
+				Handler:           metrics,
+				ReadHeaderTimeout: c.Duration("read-header-timeout"),
+			}
+			go func() {
+				slog.Info("serving metrics", "url", "http://"+metricsAddr+"/metrics")
+				if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+					slog.Error("metrics server stopped", "error", err)
+				}
+			}()
+			closers = append(closers, metricsServer)
+		}
+
+		scheme := "http"
+		if tlsConfig != nil {
+			scheme = "https"
+			if redirectAddr := c.String("tls-redirect-address"); redirectAddr != "" {
+				redirect := &http.Server{
+					Addr:              redirectAddr,
+					Handler:           todo.HTTPSRedirect{Address: listenAddr},
+					ReadHeaderTimeout: c.Duration("read-header-timeout"),
+				}
+				go func() {
+					slog.Info("redirecting to HTTPS", "address", redirectAddr)
+					if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+						slog.Error("HTTPS redirect stopped", "error", err)
+					}
+				}()
+				closers = append(closers, redirect)
+			}
+		}
+		slog.Info("starting API server", "url", fmt.Sprintf("%s://%s%s", scheme, listener.Addr(), routePrefix), "storage", storage)
+		return serve(server, listener, c.Duration("shutdown-timeout"), closers...)
+	}
+
+	app.Commands = []*cli.Command{
+		usersCommand(),
 	}
 
 	err := app.Run(os.Args)
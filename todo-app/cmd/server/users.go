@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+	"github.com/urfave/cli/v2"
+)
+
+// usersCommand manages the users file, which a running server picks up on change
+func usersCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "users",
+		Usage: "Manage users of the HTTP API",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "add",
+				Usage: "Add a new user",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "name",
+						Aliases:  []string{"n"},
+						Usage:    "Login name of the user",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "password",
+						Usage: "Password of the user, read from stdin if omitted",
+					},
+					&cli.BoolFlag{
+						Name:  "admin",
+						Usage: "Permit the user to manage users",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					store, err := todo.NewJSONFileUserStore(c.String("users"))
+					if err != nil {
+						return err
+					}
+					password, err := readPassword(c)
+					if err != nil {
+						return err
+					}
+					user := todo.User{Name: c.String("name"), Admin: c.Bool("admin")}
+					if err = user.SetPassword(password); err != nil {
+						return err
+					}
+					id, err := store.Create(user)
+					if err != nil {
+						return err
+					}
+					fmt.Println(id)
+					return nil
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "List all users",
+				Action: func(c *cli.Context) error {
+					store, err := todo.NewJSONFileUserStore(c.String("users"))
+					if err != nil {
+						return err
+					}
+					users, err := store.List()
+					if err != nil {
+						return err
+					}
+					out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(out, "ID\tNAME\tADMIN")
+					for _, user := range users {
+						fmt.Fprintf(out, "%s\t%s\t%t\n", user.ID, user.Name, user.Admin)
+					}
+					return out.Flush()
+				},
+			},
+			{
+				Name:      "remove",
+				Usage:     "Remove a user",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return errors.New("expected exactly one user ID")
+					}
+					store, err := todo.NewJSONFileUserStore(c.String("users"))
+					if err != nil {
+						return err
+					}
+					if err = todo.CheckLastAdmin(store, c.Args().First()); err != nil {
+						return err
+					}
+					return store.Delete(c.Args().First())
+				},
+			},
+			{
+				Name:      "passwd",
+				Usage:     "Change the password of a user",
+				ArgsUsage: "<id>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "password",
+						Usage: "New password of the user, read from stdin if omitted",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return errors.New("expected exactly one user ID")
+					}
+					store, err := todo.NewJSONFileUserStore(c.String("users"))
+					if err != nil {
+						return err
+					}
+					user, err := store.Get(c.Args().First())
+					if err != nil {
+						return err
+					}
+					password, err := readPassword(c)
+					if err != nil {
+						return err
+					} else if err = user.SetPassword(password); err != nil {
+						return err
+					}
+					return store.Update(*user)
+				},
+			},
+		},
+	}
+}
+
+// readPassword returns the password from the flag or the first line of stdin
+func readPassword(c *cli.Context) (string, error) {
+	if password := c.String("password"); password != "" {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		if err == nil {
+			err = errors.New("empty password")
+		}
+		return "", err
+	}
+	return password, nil
+}
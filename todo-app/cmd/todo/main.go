@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/ukautz/go-intro/todo-app/pkg/client"
+	"github.com/urfave/cli/v2"
+)
+
+// build information, injected at link time (see Makefile)
+var version = "dev"
+
+// config contains the connection settings from the config file, which flags and
+// environment variables override
+type config struct {
+	URL      string `json:"url"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	APIKey   string `json:"api_key"`
+	Token    string `json:"token"`
+}
+
+func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newApp creates the command line application with all flags and commands
+func newApp() *cli.App {
+	app := cli.NewApp()
+	app.Name = "todo"
+	app.Usage = "Command line client for the todo HTTP API"
+	app.Version = version
+	app.EnableBashCompletion = true
+
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Usage:   "Path to JSON file with url, user, password, api_key or token",
+			EnvVars: []string{"TODO_CONFIG"},
+			Value:   defaultConfigFile(),
+		},
+		&cli.StringFlag{
+			Name:    "url",
+			Usage:   "URL of the server including path prefix",
+			EnvVars: []string{"TODO_URL"},
+			Value:   "http://127.0.0.1:12345/v1",
+		},
+		&cli.StringFlag{
+			Name:    "user",
+			Aliases: []string{"u"},
+			Usage:   "User name for HTTP basic auth",
+			EnvVars: []string{"TODO_USER"},
+		},
+		&cli.StringFlag{
+			Name:    "password",
+			Usage:   "Password for HTTP basic auth",
+			EnvVars: []string{"TODO_PASSWORD"},
+		},
+		&cli.StringFlag{
+			Name:    "api-key",
+			Usage:   "API key, instead of user and password",
+			EnvVars: []string{"TODO_API_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "Bearer token of an OpenID Connect provider, instead of user and password",
+			EnvVars: []string{"TODO_TOKEN"},
+		},
+	}
+
+	app.Commands = []*cli.Command{
+		addCommand(),
+		listCommand(),
+		showCommand(),
+		removeCommand(),
+		tuiCommand(),
+		completionCommand(),
+	}
+	return app
+}
+
+// newClient creates a Client from flags, environment variables and the config
+// file, in that order
+func newClient(c *cli.Context) (*client.Client, error) {
+	cfg, err := loadConfig(c.String("config"), c.IsSet("config"))
+	if err != nil {
+		return nil, err
+	}
+	setting := func(flag, configured string) string {
+		if c.IsSet(flag) || configured == "" {
+			return c.String(flag)
+		}
+		return configured
+	}
+
+	cl := client.New(setting("url", cfg.URL))
+	cl.Username = setting("user", cfg.User)
+	cl.Password = setting("password", cfg.Password)
+	cl.APIKey = setting("api-key", cfg.APIKey)
+	cl.Token = setting("token", cfg.Token)
+	cl.UserAgent = "todo/" + version
+	return cl, nil
+}
+
+// loadConfig reads the config file. Only config files which were asked for
+// explicitly must exist. Config files contain credentials, so those which other
+// users can read are refused.
+func loadConfig(filename string, required bool) (config, error) {
+	var cfg config
+	if filename == "" {
+		return cfg, nil
+	}
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) && !required {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, err
+	} else if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return cfg, fmt.Errorf("config file %s is accessible by other users, restrict it with: chmod 600 %s", filename, filename)
+	}
+	encoded, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return cfg, err
+	} else if err = json.Unmarshal(encoded, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "todo", "config.json")
+}
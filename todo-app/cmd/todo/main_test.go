@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ukautz/go-intro/todo-app/pkg/client"
+	"github.com/urfave/cli/v2"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	valid := writeTestConfig(t, dir, "valid.json", `{"url":"http://config","user":"the-user"}`, 0600)
+	invalid := writeTestConfig(t, dir, "invalid.json", `{"url":`, 0600)
+	missing := filepath.Join(dir, "missing.json")
+
+	cfg, err := loadConfig(valid, true)
+	require.NoError(t, err)
+	assert.Equal(t, config{URL: "http://config", User: "the-user"}, cfg)
+
+	cfg, err = loadConfig(missing, false)
+	require.NoError(t, err)
+	assert.Equal(t, config{}, cfg)
+	_, err = loadConfig(missing, true)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = loadConfig(invalid, true)
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_Permissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not checked on windows")
+	}
+	dir := t.TempDir()
+
+	for _, mode := range []os.FileMode{0644, 0640, 0604} {
+		filename := writeTestConfig(t, dir, "config.json", `{"password":"the-pass"}`, mode)
+		_, err := loadConfig(filename, false)
+		assert.Error(t, err, "mode %o", mode)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	dir := t.TempDir()
+	filename := writeTestConfig(t, dir, "config.json", `{"url":"http://config","user":"config-user","password":"config-pass","api_key":"config-key"}`, 0600)
+	unsetTestEnv(t)
+
+	// the config file overrides defaults
+	cl := runTestClient(t, "--config", filename)
+	assert.Equal(t, "http://config", cl.BaseURL)
+	assert.Equal(t, "config-user", cl.Username)
+	assert.Equal(t, "config-pass", cl.Password)
+	assert.Equal(t, "config-key", cl.APIKey)
+	assert.Equal(t, "", cl.Token)
+
+	// environment variables override the config file
+	os.Setenv("TODO_URL", "http://env")
+	os.Setenv("TODO_USER", "env-user")
+	cl = runTestClient(t, "--config", filename)
+	assert.Equal(t, "http://env", cl.BaseURL)
+	assert.Equal(t, "env-user", cl.Username)
+	assert.Equal(t, "config-pass", cl.Password)
+
+	// flags override environment variables
+	cl = runTestClient(t, "--config", filename, "--url", "http://flag", "--token", "flag-token")
+	assert.Equal(t, "http://flag", cl.BaseURL)
+	assert.Equal(t, "env-user", cl.Username)
+	assert.Equal(t, "flag-token", cl.Token)
+
+	// defaults apply without config file
+	unsetTestEnv(t)
+	cl = runTestClient(t, "--config", "")
+	assert.Equal(t, "http://127.0.0.1:12345/v1", cl.BaseURL)
+	assert.Equal(t, "", cl.Username)
+
+	// config files asked for must exist
+	app := newApp()
+	app.Commands = []*cli.Command{{Name: "client", Action: func(c *cli.Context) error {
+		_, err := newClient(c)
+		return err
+	}}}
+	assert.Error(t, app.Run([]string{"todo", "--config", filepath.Join(dir, "missing.json"), "client"}))
+}
+
+// runTestClient runs the application with the global flags and returns the
+// Client created from them
+func runTestClient(t *testing.T, args ...string) *client.Client {
+	var cl *client.Client
+	app := newApp()
+	app.Commands = []*cli.Command{{Name: "client", Action: func(c *cli.Context) (err error) {
+		cl, err = newClient(c)
+		return err
+	}}}
+	require.NoError(t, app.Run(append(append([]string{"todo"}, args...), "client")))
+	return cl
+}
+
+// unsetTestEnv removes the environment variables of the application until the
+// end of the test
+func unsetTestEnv(t *testing.T) {
+	for _, name := range []string{"TODO_CONFIG", "TODO_URL", "TODO_USER", "TODO_PASSWORD", "TODO_API_KEY", "TODO_TOKEN"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func writeTestConfig(t *testing.T, dir, name, content string, mode os.FileMode) string {
+	filename := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), mode))
+	require.NoError(t, os.Chmod(filename, mode))
+	return filename
+}
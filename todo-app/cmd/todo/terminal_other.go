@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+var unsupportedTerminalError = fmt.Errorf("terminal UI is not supported on %s", runtime.GOOS)
+
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, unsupportedTerminalError
+}
+
+func terminalSize(fd uintptr) (width, height int, err error) {
+	return 0, 0, unsupportedTerminalError
+}
+
+func resizeSignals() []os.Signal {
+	return nil
+}
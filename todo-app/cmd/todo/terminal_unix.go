@@ -0,0 +1,58 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// makeRaw puts the terminal into raw mode, so that key presses are read
+// unbuffered and without echo, and returns a function restoring the prior state
+func makeRaw(fd uintptr) (func(), error) {
+	var prior syscall.Termios
+	if err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&prior)); err != nil {
+		return nil, err
+	}
+
+	raw := prior
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlSetTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		ioctl(fd, ioctlSetTermios, unsafe.Pointer(&prior))
+	}, nil
+}
+
+// terminalSize returns the width and height of the terminal in characters
+func terminalSize(fd uintptr) (width, height int, err error) {
+	var size struct {
+		Rows, Cols, X, Y uint16
+	}
+	if err = ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0, 0, err
+	}
+	return int(size.Cols), int(size.Rows), nil
+}
+
+// resizeSignals are the signals sent on changes of the terminal size
+func resizeSignals() []os.Signal {
+	return []os.Signal{syscall.SIGWINCH}
+}
+
+func ioctl(fd, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+	"github.com/urfave/cli/v2"
+)
+
+// output formats of todos
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+func addCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "add",
+		Usage:     "Add a new todo and print its ID",
+		ArgsUsage: "<title>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "description",
+				Aliases: []string{"d"},
+				Usage:   "Longer description of the todo",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			title, description, err := titleAndDescription(c)
+			if err != nil {
+				return err
+			}
+			cl, err := newClient(c)
+			if err != nil {
+				return err
+			}
+			id, err := cl.Create(c.Context, todo.Todo{Title: title, Description: description})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(c.App.Writer, id)
+			return nil
+		},
+	}
+}
+
+// titleAndDescription returns the title argument and the description flag, which
+// may also follow the title as in: todo add "title" -d "description"
+func titleAndDescription(c *cli.Context) (title, description string, err error) {
+	description = c.String("description")
+	args := c.Args().Slice()
+	if len(args) == 0 {
+		return "", "", errors.New("expected exactly one title, use quotes for titles with spaces")
+	}
+	title, args = args[0], args[1:]
+	for len(args) > 0 {
+		arg := args[0]
+		switch {
+		case arg == "-d" || arg == "--description":
+			if len(args) < 2 {
+				return "", "", fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			description, args = args[1], args[2:]
+		case strings.HasPrefix(arg, "-d=") || strings.HasPrefix(arg, "--description="):
+			description, args = arg[strings.Index(arg, "=")+1:], args[1:]
+		default:
+			return "", "", errors.New("expected exactly one title, use quotes for titles with spaces")
+		}
+	}
+	return title, description, nil
+}
+
+func listCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "ls",
+		Aliases: []string{"list"},
+		Usage:   "List all todos, oldest first",
+		Flags:   []cli.Flag{outputFlag(outputTable, outputJSON, outputCSV)},
+		Action: func(c *cli.Context) error {
+			cl, err := newClient(c)
+			if err != nil {
+				return err
+			}
+			todos, err := cl.List(c.Context)
+			if err != nil {
+				return err
+			}
+			sort.Slice(todos, func(i, j int) bool {
+				return todos[i].Created.Before(todos[j].Created)
+			})
+			return writeTodos(c.App.Writer, todos, c.String("output"))
+		},
+	}
+}
+
+// writeTodos writes the todos in the output format
+func writeTodos(w io.Writer, todos []todo.Todo, format string) error {
+	switch format {
+	case outputJSON:
+		return writeJSON(w, todos)
+	case outputCSV:
+		out := csv.NewWriter(w)
+		out.Write([]string{"id", "title", "description", "created", "user_id"})
+		for _, td := range todos {
+			out.Write([]string{td.ID, td.Title, td.Description, td.Created.Format(time.RFC3339), td.UserID})
+		}
+		out.Flush()
+		return out.Error()
+	case outputTable:
+		out := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(out, "ID\tCREATED\tTITLE")
+		for _, td := range todos {
+			fmt.Fprintf(out, "%s\t%s\t%s\n", td.ID, td.Created.Local().Format("2006-01-02 15:04"), td.Title)
+		}
+		return out.Flush()
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func showCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "show",
+		Usage:        "Show a todo",
+		ArgsUsage:    "<id>",
+		Flags:        []cli.Flag{outputFlag(outputTable, outputJSON)},
+		BashComplete: completeIDs,
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return errors.New("expected exactly one todo ID")
+			}
+			cl, err := newClient(c)
+			if err != nil {
+				return err
+			}
+			td, err := cl.Get(c.Context, c.Args().First())
+			if err != nil {
+				return err
+			}
+
+			switch c.String("output") {
+			case outputJSON:
+				return writeJSON(c.App.Writer, td)
+			case outputTable:
+				out := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+				fmt.Fprintf(out, "ID:\t%s\n", td.ID)
+				fmt.Fprintf(out, "Title:\t%s\n", td.Title)
+				fmt.Fprintf(out, "Description:\t%s\n", td.Description)
+				fmt.Fprintf(out, "Created:\t%s\n", td.Created.Local().Format("2006-01-02 15:04:05"))
+				fmt.Fprintf(out, "User:\t%s\n", td.UserID)
+				return out.Flush()
+			}
+			return fmt.Errorf("unknown output format %q", c.String("output"))
+		},
+	}
+}
+
+func removeCommand() *cli.Command {
+	return &cli.Command{
+		Name:         "rm",
+		Aliases:      []string{"remove"},
+		Usage:        "Remove todos",
+		ArgsUsage:    "<id> [<id> ...]",
+		BashComplete: completeIDs,
+		Action: func(c *cli.Context) error {
+			if c.NArg() == 0 {
+				return errors.New("expected at least one todo ID")
+			}
+			cl, err := newClient(c)
+			if err != nil {
+				return err
+			}
+			for _, id := range c.Args().Slice() {
+				if err = cl.Delete(c.Context, id); err != nil {
+					return fmt.Errorf("remove %s: %w", id, err)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func outputFlag(formats ...string) cli.Flag {
+	return &cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   fmt.Sprintf("Output format, one of %v", formats),
+		Value:   formats[0],
+	}
+}
+
+func writeJSON(out io.Writer, data interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// completeIDs prints the IDs of all todos for shell completion, or nothing if the
+// server can't be reached quickly
+func completeIDs(c *cli.Context) {
+	cl, err := newClient(c)
+	if err != nil {
+		return
+	}
+	cl.MaxRetries = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	todos, err := cl.List(ctx)
+	if err != nil {
+		return
+	}
+	for _, td := range todos {
+		fmt.Fprintln(c.App.Writer, td.ID)
+	}
+}
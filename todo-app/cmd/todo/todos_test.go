@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+	"github.com/urfave/cli/v2"
+)
+
+func TestTitleAndDescription(t *testing.T) {
+	expects := []struct {
+		name        string
+		args        []string
+		title       string
+		description string
+		err         bool
+	}{
+		{"title only", []string{"the title"}, "the title", "", false},
+		{"flag before title", []string{"-d", "the description", "the title"}, "the title", "the description", false},
+		{"flag after title", []string{"the title", "-d", "the description"}, "the title", "the description", false},
+		{"long flag after title", []string{"the title", "--description", "the description"}, "the title", "the description", false},
+		{"flag with equals after title", []string{"the title", "-d=the description"}, "the title", "the description", false},
+		{"long flag with equals after title", []string{"the title", "--description=the description"}, "the title", "the description", false},
+		{"empty description after title", []string{"the title", "-d="}, "the title", "", false},
+		{"missing title", []string{}, "", "", true},
+		{"missing title after flag", []string{"-d", "the description"}, "", "", true},
+		{"unquoted title", []string{"the", "title"}, "", "", true},
+		{"flag without argument", []string{"the title", "-d"}, "", "", true},
+		{"unknown flag after title", []string{"the title", "-x"}, "", "", true},
+	}
+
+	for _, expect := range expects {
+		t.Run(expect.name, func(t *testing.T) {
+			var title, description string
+			var err error
+			command := addCommand()
+			command.Action = func(c *cli.Context) error {
+				title, description, err = titleAndDescription(c)
+				return nil
+			}
+			app := cli.NewApp()
+			app.Commands = []*cli.Command{command}
+			require.NoError(t, app.Run(append([]string{"todo", "add"}, expect.args...)))
+
+			if expect.err {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, expect.title, title)
+				assert.Equal(t, expect.description, description)
+			}
+		})
+	}
+}
+
+func TestWriteTodos(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	todos := []todo.Todo{
+		{ID: "id-1", Title: "the-title", Description: "the, description", Created: created, UserID: "u01"},
+		{ID: "id-22", Title: "other title", Created: created.Add(time.Hour), UserID: "u01"},
+	}
+
+	t.Run("table", func(t *testing.T) {
+		out := new(bytes.Buffer)
+		require.NoError(t, writeTodos(out, todos, outputTable))
+		assert.Equal(t, "ID     CREATED           TITLE\n"+
+			"id-1   "+created.Local().Format("2006-01-02 15:04")+"  the-title\n"+
+			"id-22  "+created.Add(time.Hour).Local().Format("2006-01-02 15:04")+"  other title\n", out.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		out := new(bytes.Buffer)
+		require.NoError(t, writeTodos(out, todos, outputJSON))
+		var decoded []todo.Todo
+		require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+		assert.Equal(t, todos, decoded)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		out := new(bytes.Buffer)
+		require.NoError(t, writeTodos(out, todos, outputCSV))
+		assert.Equal(t, "id,title,description,created,user_id\n"+
+			"id-1,the-title,\"the, description\",2020-01-02T03:04:05Z,u01\n"+
+			"id-22,other title,,2020-01-02T04:04:05Z,u01\n", out.String())
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		assert.Error(t, writeTodos(new(bytes.Buffer), todos, "yaml"))
+	})
+}
+
+func TestListCommand(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "/todo", req.URL.Path)
+		rw.Header().Set("content-type", "application/json")
+		rw.Write([]byte(`[
+			{"id":"newer","title":"the-newer","created":"2020-01-02T04:00:00Z"},
+			{"id":"older","title":"the-older","created":"2020-01-02T03:00:00Z"}
+		]`))
+	}))
+	defer server.Close()
+	unsetTestEnv(t)
+
+	// todos are listed oldest first
+	out := new(bytes.Buffer)
+	app := newApp()
+	app.Writer = out
+	require.NoError(t, app.Run([]string{"todo", "--config", "", "--url", server.URL, "ls", "-o", "csv"}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[1], "older,"))
+	assert.True(t, strings.HasPrefix(lines[2], "newer,"))
+}
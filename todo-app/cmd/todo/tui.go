@@ -0,0 +1,614 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+	"github.com/ukautz/go-intro/todo-app/pkg/client"
+	"github.com/urfave/cli/v2"
+)
+
+// key is a printable character or one of the named keys below
+type key string
+
+const (
+	keyUp        key = "<up>"
+	keyDown      key = "<down>"
+	keyPageUp    key = "<page-up>"
+	keyPageDown  key = "<page-down>"
+	keyHome      key = "<home>"
+	keyEnd       key = "<end>"
+	keyEnter     key = "<enter>"
+	keyEscape    key = "<escape>"
+	keyBackspace key = "<backspace>"
+	keyDelete    key = "<delete>"
+	keyCtrlC     key = "<ctrl-c>"
+	keyCtrlU     key = "<ctrl-u>"
+)
+
+// escapeSequences maps the sequences terminals send for special keys
+var escapeSequences = map[string]key{
+	"\x1b[A": keyUp, "\x1bOA": keyUp,
+	"\x1b[B": keyDown, "\x1bOB": keyDown,
+	"\x1b[H": keyHome, "\x1bOH": keyHome, "\x1b[1~": keyHome, "\x1b[7~": keyHome,
+	"\x1b[F": keyEnd, "\x1bOF": keyEnd, "\x1b[4~": keyEnd, "\x1b[8~": keyEnd,
+	"\x1b[5~": keyPageUp,
+	"\x1b[6~": keyPageDown,
+	"\x1b[3~": keyDelete,
+}
+
+// tuiMode is what key presses currently do
+type tuiMode int
+
+const (
+	modeBrowse tuiMode = iota
+	modeFilter
+	modeTitle
+	modeDescription
+	modeConfirmDelete
+)
+
+// tuiResult is the outcome of an operation on the Persistence, which is run in
+// the background to keep the UI responsive
+type tuiResult struct {
+	todos    []todo.Todo
+	selectID string
+	status   string
+	err      error
+}
+
+// tui is a full screen terminal UI over a Persistence
+type tui struct {
+	store   todo.Persistence
+	source  string
+	out     io.Writer
+	results chan tuiResult
+
+	todos    []todo.Todo // all todos, oldest first
+	visible  []todo.Todo // todos matching the filter
+	selected int
+	offset   int
+	filter   string
+	mode     tuiMode
+	input    string
+	draft    todo.Todo
+	deleting todo.Todo // todo to delete, once confirmed
+	status   string
+	pending  int
+	width    int
+	height   int
+	quit     bool
+}
+
+func tuiCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "tui",
+		Usage: "Browse and edit todos in an interactive terminal UI",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "directory",
+				Usage: "Path to directory with todos, used directly instead of the server",
+			},
+			&cli.DurationFlag{
+				Name:  "refresh",
+				Usage: "Interval for reloading the todos, 0 disables (press r to reload)",
+				Value: 5 * time.Second,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ui := &tui{out: os.Stdout, results: make(chan tuiResult)}
+			if directory := c.String("directory"); directory != "" {
+				store := todo.DirectoryPersistence(directory)
+				if err := store.Writable(); err != nil {
+					return err
+				}
+				ui.store, ui.source = store, directory
+			} else {
+				cl, err := newClient(c)
+				if err != nil {
+					return err
+				}
+				ui.store, ui.source = &client.RemotePersistence{Client: cl}, cl.BaseURL
+			}
+			return ui.run(c.Duration("refresh"))
+		},
+	}
+}
+
+// run shows the UI until the user quits
+func (t *tui) run(refresh time.Duration) error {
+	fd := os.Stdin.Fd()
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("terminal UI requires a terminal: %w", err)
+	}
+	defer restore()
+
+	// use the alternate screen, which leaves the scrollback untouched
+	fmt.Fprint(t.out, "\x1b[?1049h")
+	defer fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, resizeSignals()...)
+	defer signal.Stop(resized)
+	var ticks <-chan time.Time
+	if refresh > 0 {
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	keys := make(chan []key)
+	go readKeys(os.Stdin, keys)
+
+	t.resize(fd)
+	t.load(nil, "")
+	for !t.quit {
+		t.draw()
+		select {
+		case pressed, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, k := range pressed {
+				t.press(k)
+			}
+		case res := <-t.results:
+			t.update(res)
+		case <-resized:
+			t.resize(fd)
+		case <-ticks:
+			if t.pending == 0 {
+				t.load(nil, "")
+			}
+		}
+	}
+	return nil
+}
+
+// load runs the operation, if any, and then reloads the todos in the background.
+// The operation returns the ID of the todo to select afterwards.
+func (t *tui) load(operation func() (string, error), done string) {
+	t.pending++
+	go func() {
+		res := tuiResult{status: done}
+		if operation != nil {
+			res.selectID, res.err = operation()
+		}
+		if res.err == nil {
+			res.todos, res.err = t.store.List()
+		}
+		t.results <- res
+	}()
+}
+
+// update applies the result of a background operation
+func (t *tui) update(res tuiResult) {
+	t.pending--
+	if res.err != nil {
+		t.status = "Error: " + res.err.Error()
+		return
+	} else if res.status != "" {
+		t.status = res.status
+	}
+	sort.Slice(res.todos, func(i, j int) bool {
+		return res.todos[i].Created.Before(res.todos[j].Created)
+	})
+	selectID := res.selectID
+	if selectID == "" {
+		selectID = t.selectedID()
+	}
+	t.todos = res.todos
+	t.apply(selectID)
+}
+
+// apply the filter to the todos and select the todo with the ID, or keep the
+// position if it is gone
+func (t *tui) apply(selectID string) {
+	needle := strings.ToLower(t.filter)
+	t.visible = t.visible[:0]
+	for _, td := range t.todos {
+		if strings.Contains(strings.ToLower(td.Title+"\n"+td.Description), needle) {
+			if td.ID == selectID {
+				t.selected = len(t.visible)
+			}
+			t.visible = append(t.visible, td)
+		}
+	}
+	t.move(0)
+}
+
+func (t *tui) selectedID() string {
+	if t.selected < len(t.visible) {
+		return t.visible[t.selected].ID
+	}
+	return ""
+}
+
+// move the selection by delta rows and scroll the list to keep it visible
+func (t *tui) move(delta int) {
+	t.selected += delta
+	if t.selected >= len(t.visible) {
+		t.selected = len(t.visible) - 1
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+	rows := t.bodyHeight()
+	if t.selected < t.offset {
+		t.offset = t.selected
+	} else if t.selected >= t.offset+rows {
+		t.offset = t.selected - rows + 1
+	}
+	if max := len(t.visible) - rows; t.offset > max {
+		t.offset = max
+	}
+	if t.offset < 0 {
+		t.offset = 0
+	}
+}
+
+func (t *tui) resize(fd uintptr) {
+	width, height, err := terminalSize(fd)
+	if err != nil || width == 0 || height == 0 {
+		width, height = 80, 24
+	}
+	t.width, t.height = width, height
+	t.move(0)
+}
+
+func (t *tui) bodyHeight() int {
+	if t.height < 3 {
+		return 1
+	}
+	return t.height - 2
+}
+
+// press handles a key according to the mode
+func (t *tui) press(k key) {
+	if k == keyCtrlC {
+		t.quit = true
+		return
+	}
+
+	switch t.mode {
+	case modeBrowse:
+		t.status = ""
+		switch k {
+		case "q":
+			t.quit = true
+		case keyUp, "k":
+			t.move(-1)
+		case keyDown, "j":
+			t.move(1)
+		case keyPageUp:
+			t.move(-t.bodyHeight())
+		case keyPageDown:
+			t.move(t.bodyHeight())
+		case keyHome, "g":
+			t.move(-len(t.visible))
+		case keyEnd, "G":
+			t.move(len(t.visible))
+		case "/":
+			t.mode, t.input = modeFilter, t.filter
+		case keyEscape:
+			t.filter = ""
+			t.apply(t.selectedID())
+		case "a":
+			t.mode, t.input, t.draft = modeTitle, "", todo.Todo{}
+		case "d", keyDelete:
+			if len(t.visible) > 0 {
+				t.mode, t.deleting = modeConfirmDelete, t.visible[t.selected]
+			}
+		case "r":
+			t.load(nil, "Reloaded")
+		}
+
+	case modeFilter:
+		switch k {
+		case keyUp, keyDown, keyPageUp, keyPageDown:
+			t.mode = modeBrowse
+			t.press(k)
+			t.mode = modeFilter
+		case keyEnter:
+			t.mode = modeBrowse
+		case keyEscape:
+			t.mode, t.input = modeBrowse, ""
+		default:
+			t.edit(k)
+		}
+		t.filter = t.input
+		t.apply(t.selectedID())
+
+	case modeTitle:
+		switch k {
+		case keyEnter:
+			t.draft.Title = t.input
+			t.mode, t.input = modeDescription, ""
+		case keyEscape:
+			t.mode = modeBrowse
+		default:
+			t.edit(k)
+		}
+
+	case modeDescription:
+		switch k {
+		case keyEnter:
+			t.draft.Description = t.input
+			t.mode = modeBrowse
+			if err := t.draft.Validate(); err != nil {
+				t.status = "Error: " + err.Error()
+				return
+			}
+			draft := t.draft
+			t.load(func() (string, error) {
+				return t.store.Create(draft)
+			}, fmt.Sprintf("Added %q", sanitize(draft.Title)))
+		case keyEscape:
+			t.mode = modeBrowse
+		default:
+			t.edit(k)
+		}
+
+	case modeConfirmDelete:
+		t.mode = modeBrowse
+		if k == "y" {
+			td := t.deleting
+			t.load(func() (string, error) {
+				return "", t.store.Delete(td.ID)
+			}, fmt.Sprintf("Deleted %q", sanitize(td.Title)))
+		}
+	}
+}
+
+// edit the input of a prompt
+func (t *tui) edit(k key) {
+	switch {
+	case k == keyBackspace:
+		if _, size := utf8.DecodeLastRuneInString(t.input); size > 0 {
+			t.input = t.input[:len(t.input)-size]
+		}
+	case k == keyCtrlU:
+		t.input = ""
+	case utf8.RuneCountInString(string(k)) == 1:
+		t.input += string(k)
+	}
+}
+
+// draw renders the whole screen at once: a header, the list of todos next to the
+// details of the selected one and a footer with help, status or prompt
+func (t *tui) draw() {
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[?25l")
+
+	header := fmt.Sprintf(" todo - %s - %d todos", t.source, len(t.todos))
+	if t.filter != "" {
+		header = fmt.Sprintf(" todo - %s - %d of %d todos matching %q", t.source, len(t.visible), len(t.todos), t.filter)
+	}
+	if t.pending > 0 {
+		header += " - loading"
+	}
+	fmt.Fprintf(&buf, "\x1b[1;1H\x1b[7m%s\x1b[0m", fit(header, t.width))
+
+	listWidth, detailWidth := t.width, 0
+	if t.width >= 60 {
+		listWidth = t.width * 2 / 5
+		detailWidth = t.width - listWidth - 3
+	}
+	var details []string
+	if t.selected < len(t.visible) && detailWidth > 0 {
+		details = t.details(t.visible[t.selected], detailWidth)
+	}
+	for row := 0; row < t.bodyHeight(); row++ {
+		fmt.Fprintf(&buf, "\x1b[%d;1H", row+2)
+		if index := t.offset + row; index < len(t.visible) {
+			line := " " + fit(sanitize(t.visible[index].Title), listWidth-2) + " "
+			if index == t.selected {
+				line = "\x1b[7m" + line + "\x1b[0m"
+			}
+			buf.WriteString(line)
+		} else if row == 0 && len(t.visible) == 0 {
+			empty := "no todos"
+			if t.pending > 0 {
+				empty = "loading"
+			} else if t.filter != "" {
+				empty = "no matching todos"
+			}
+			buf.WriteString(" " + fit(empty, listWidth-1))
+		} else {
+			buf.WriteString(fit("", listWidth))
+		}
+		if detailWidth > 0 {
+			detail := ""
+			if row < len(details) {
+				detail = details[row]
+			}
+			buf.WriteString(" │ " + fit(detail, detailWidth))
+		}
+	}
+
+	footer, prompt := t.footer()
+	fmt.Fprintf(&buf, "\x1b[%d;1H%s", t.height, fit(footer, t.width-1))
+	if prompt {
+		// show the cursor behind the prompt input
+		fmt.Fprintf(&buf, "\x1b[%d;%dH\x1b[?25h", t.height, utf8.RuneCountInString(footer)+1)
+	}
+	t.out.Write(buf.Bytes())
+}
+
+// footer returns the last line and whether it is a prompt for input
+func (t *tui) footer() (string, bool) {
+	switch t.mode {
+	case modeFilter:
+		return "/" + sanitize(t.input), true
+	case modeTitle:
+		return "Title: " + sanitize(t.input), true
+	case modeDescription:
+		return "Description: " + sanitize(t.input), true
+	case modeConfirmDelete:
+		return fmt.Sprintf("Delete %q? (y/n)", sanitize(t.deleting.Title)), false
+	}
+	if t.status != "" {
+		return " " + sanitize(t.status), false
+	}
+	return " ↑↓ move  / filter  a add  d delete  r reload  q quit", false
+}
+
+// details returns the lines describing the todo, which may contain anything
+// the server sent
+func (t *tui) details(td todo.Todo, width int) []string {
+	lines := []string{
+		"ID:      " + sanitize(td.ID),
+		"Created: " + td.Created.Local().Format("2006-01-02 15:04:05"),
+	}
+	if td.UserID != "" {
+		lines = append(lines, "User:    "+sanitize(td.UserID))
+	}
+	lines = append(lines, "")
+	lines = append(lines, wrap(td.Title, width)...)
+	if td.Description != "" {
+		lines = append(lines, "")
+		lines = append(lines, wrap(td.Description, width)...)
+	}
+	return lines
+}
+
+// readKeys sends the keys pressed until reading fails
+func readKeys(in io.Reader, keys chan<- []key) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			keys <- parseKeys(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseKeys returns the keys of the input read from the terminal in raw mode
+func parseKeys(input []byte) []key {
+	var keys []key
+	for len(input) > 0 {
+		switch b := input[0]; {
+		case b == 0x1b:
+			size := escapeSequenceSize(input)
+			if k, ok := escapeSequences[string(input[:size])]; ok {
+				keys = append(keys, k)
+			} else if size == 1 {
+				keys = append(keys, keyEscape)
+			}
+			input = input[size:]
+			continue
+		case b == '\r' || b == '\n':
+			keys = append(keys, keyEnter)
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, keyBackspace)
+		case b == 0x03:
+			keys = append(keys, keyCtrlC)
+		case b == 0x15:
+			keys = append(keys, keyCtrlU)
+		case b >= 0x20:
+			r, size := utf8.DecodeRune(input)
+			if r != utf8.RuneError {
+				keys = append(keys, key(string(r)))
+			}
+			input = input[size:]
+			continue
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+// escapeSequenceSize returns the length of the escape sequence at the start of
+// the input, which is 1 for the escape key itself
+func escapeSequenceSize(input []byte) int {
+	if len(input) < 2 {
+		return 1
+	}
+	switch input[1] {
+	case 'O':
+		if len(input) < 3 {
+			return 2
+		}
+		return 3
+	case '[':
+		// parameters followed by a final byte
+		for i := 2; i < len(input); i++ {
+			if input[i] >= 0x40 && input[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(input)
+	}
+	return 1
+}
+
+// sanitize replaces control characters, which would mess up the screen
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// fit truncates or pads the text to exactly width characters
+func fit(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	count := utf8.RuneCountInString(s)
+	if count <= width {
+		return s + strings.Repeat(" ", width-count)
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// wrap breaks the text into lines of at most width characters at spaces, or
+// within words longer than that
+func wrap(s string, width int) []string {
+	var lines []string
+	if width <= 0 {
+		return lines
+	}
+	for _, paragraph := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(sanitize(paragraph)) {
+			for utf8.RuneCountInString(word) > width {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				runes := []rune(word)
+				lines = append(lines, string(runes[:width]))
+				word = string(runes[width:])
+			}
+			if word == "" {
+				continue
+			} else if line == "" {
+				line = word
+			} else if utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width {
+				line += " " + word
+			} else {
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestParseKeys(t *testing.T) {
+	expects := []struct {
+		name  string
+		input string
+		keys  []key
+	}{
+		{"character", "a", []key{"a"}},
+		{"multi byte character", "ä", []key{"ä"}},
+		{"characters", "ab", []key{"a", "b"}},
+		{"cursor up", "\x1b[A", []key{keyUp}},
+		{"cursor down in application mode", "\x1bOB", []key{keyDown}},
+		{"home with parameter", "\x1b[1~", []key{keyHome}},
+		{"delete", "\x1b[3~", []key{keyDelete}},
+		{"escape key", "\x1b", []key{keyEscape}},
+		{"unknown escape sequence", "\x1b[99~", nil},
+		{"carriage return", "\r", []key{keyEnter}},
+		{"newline", "\n", []key{keyEnter}},
+		{"backspace", "\x7f", []key{keyBackspace}},
+		{"ctrl-h", "\x08", []key{keyBackspace}},
+		{"ctrl-c", "\x03", []key{keyCtrlC}},
+		{"ctrl-u", "\x15", []key{keyCtrlU}},
+		{"other control character", "\x01", nil},
+		{"invalid UTF-8", "\xff", nil},
+		{"mixed", "ab\x1b[Bq\r", []key{"a", "b", keyDown, "q", keyEnter}},
+	}
+
+	for _, expect := range expects {
+		t.Run(expect.name, func(t *testing.T) {
+			assert.Equal(t, expect.keys, parseKeys([]byte(expect.input)))
+		})
+	}
+}
+
+func TestEscapeSequenceSize(t *testing.T) {
+	expects := []struct {
+		input string
+		size  int
+	}{
+		{"\x1b", 1},
+		{"\x1bx", 1},
+		{"\x1bO", 2},
+		{"\x1bOA", 3},
+		{"\x1bOAq", 3},
+		{"\x1b[A", 3},
+		{"\x1b[Aq", 3},
+		{"\x1b[5~", 4},
+		{"\x1b[1;5A", 6},
+		{"\x1b[12", 4},
+	}
+
+	for _, expect := range expects {
+		t.Run(fmt.Sprintf("%q", expect.input), func(t *testing.T) {
+			assert.Equal(t, expect.size, escapeSequenceSize([]byte(expect.input)))
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	expects := []struct {
+		name  string
+		text  string
+		width int
+		lines []string
+	}{
+		{"empty", "", 10, []string{""}},
+		{"fits", "the title", 20, []string{"the title"}},
+		{"exactly fits", "the title", 9, []string{"the title"}},
+		{"breaks at spaces", "one two three", 7, []string{"one two", "three"}},
+		{"collapses spaces", "one   two", 10, []string{"one two"}},
+		{"breaks long words", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"breaks long words after others", "ab abcdef", 4, []string{"ab", "abcd", "ef"}},
+		{"keeps paragraphs", "one\n\ntwo", 10, []string{"one", "", "two"}},
+		{"counts characters", "äöü äöü", 3, []string{"äöü", "äöü"}},
+		{"replaces control characters", "a\tb\x1b[2J", 10, []string{"a b [2J"}},
+		{"no width", "the title", 0, nil},
+	}
+
+	for _, expect := range expects {
+		t.Run(expect.name, func(t *testing.T) {
+			assert.Equal(t, expect.lines, wrap(expect.text, expect.width))
+		})
+	}
+}
+
+func TestFit(t *testing.T) {
+	expects := []struct {
+		text   string
+		width  int
+		fitted string
+	}{
+		{"abc", 5, "abc  "},
+		{"abc", 3, "abc"},
+		{"abcdef", 4, "abc…"},
+		{"abc", 1, "…"},
+		{"äöü", 2, "ä…"},
+		{"", 2, "  "},
+		{"abc", 0, ""},
+		{"abc", -1, ""},
+	}
+
+	for _, expect := range expects {
+		t.Run(fmt.Sprintf("%q %d", expect.text, expect.width), func(t *testing.T) {
+			assert.Equal(t, expect.fitted, fit(expect.text, expect.width))
+		})
+	}
+}
+
+func TestTui_Apply(t *testing.T) {
+	expects := []struct {
+		name     string
+		filter   string
+		selected int
+		selectID string
+		visible  []string
+		expect   int
+		offset   int
+	}{
+		{"selects the ID", "", 0, "id-3", []string{"id-1", "id-2", "id-3", "id-4", "id-5"}, 2, 0},
+		{"scrolls to the ID", "", 0, "id-5", []string{"id-1", "id-2", "id-3", "id-4", "id-5"}, 4, 2},
+		{"filters title and description", "EVEN", 0, "id-4", []string{"id-2", "id-4"}, 1, 0},
+		{"keeps position of missing ID", "", 1, "missing", []string{"id-1", "id-2", "id-3", "id-4", "id-5"}, 1, 0},
+		{"clamps position of missing ID", "even", 4, "", []string{"id-2", "id-4"}, 1, 0},
+		{"nothing matches", "missing", 3, "", nil, 0, 0},
+	}
+
+	for _, expect := range expects {
+		t.Run(expect.name, func(t *testing.T) {
+			ui := newTestTui(5)
+			ui.filter, ui.selected = expect.filter, expect.selected
+			ui.apply(expect.selectID)
+
+			var visible []string
+			for _, td := range ui.visible {
+				visible = append(visible, td.ID)
+			}
+			assert.Equal(t, expect.visible, visible)
+			assert.Equal(t, expect.expect, ui.selected)
+			assert.Equal(t, expect.offset, ui.offset)
+		})
+	}
+}
+
+func TestTui_Move(t *testing.T) {
+	expects := []struct {
+		name     string
+		selected int
+		offset   int
+		delta    int
+		expect   int
+		scrolled int
+	}{
+		{"down", 0, 0, 1, 1, 0},
+		{"down scrolls", 2, 0, 1, 3, 1},
+		{"up", 3, 1, -1, 2, 1},
+		{"up scrolls", 1, 1, -1, 0, 0},
+		{"stops at the end", 8, 7, 5, 9, 7},
+		{"stops at the start", 1, 0, -5, 0, 0},
+		{"page down", 0, 0, 3, 3, 1},
+		{"page up", 9, 7, -3, 6, 6},
+	}
+
+	for _, expect := range expects {
+		t.Run(expect.name, func(t *testing.T) {
+			ui := newTestTui(10)
+			ui.selected, ui.offset = expect.selected, expect.offset
+			ui.move(expect.delta)
+			assert.Equal(t, expect.expect, ui.selected)
+			assert.Equal(t, expect.scrolled, ui.offset)
+		})
+	}
+}
+
+func TestTui_Press(t *testing.T) {
+	expects := []struct {
+		name     string
+		keys     []key
+		mode     tuiMode
+		filter   string
+		input    string
+		selected int
+		quit     bool
+	}{
+		{"down", []key{keyDown, "j"}, modeBrowse, "", "", 2, false},
+		{"up", []key{keyEnd, keyUp, "k"}, modeBrowse, "", "", 2, false},
+		{"end and home", []key{"G", "g"}, modeBrowse, "", "", 0, false},
+		{"page down", []key{keyPageDown}, modeBrowse, "", "", 3, false},
+		{"quit", []key{"q"}, modeBrowse, "", "", 0, true},
+		{"ctrl-c quits prompts", []key{"a", keyCtrlC}, modeTitle, "", "", 0, true},
+		{"filter", []key{"/", "e", "v", "e", "n"}, modeFilter, "even", "even", 0, false},
+		{"filter moves selection", []key{"/", "e", "v", keyDown}, modeFilter, "ev", "ev", 1, false},
+		{"filter edits", []key{"/", "e", "x", keyBackspace, "v", keyCtrlU, "o"}, modeFilter, "o", "o", 1, false},
+		{"filter confirmed", []key{"/", "o", "d", "d", keyEnter}, modeBrowse, "odd", "odd", 0, false},
+		{"filter canceled", []key{"/", "o", "d", "d", keyEscape}, modeBrowse, "", "", 0, false},
+		{"filter cleared", []key{"/", "o", keyEnter, keyEscape}, modeBrowse, "", "o", 0, false},
+		{"add asks for title", []key{"a", "x"}, modeTitle, "", "x", 0, false},
+		{"add asks for description", []key{"a", "x", keyEnter, "y"}, modeDescription, "", "y", 0, false},
+		{"add canceled", []key{"a", "x", keyEscape}, modeBrowse, "", "x", 0, false},
+		{"delete asks", []key{keyDown, "d"}, modeConfirmDelete, "", "", 1, false},
+		{"delete canceled", []key{keyDown, keyDelete, "n"}, modeBrowse, "", "", 1, false},
+	}
+
+	for _, expect := range expects {
+		t.Run(expect.name, func(t *testing.T) {
+			ui := newTestTui(5)
+			for _, k := range expect.keys {
+				ui.press(k)
+			}
+			assert.Equal(t, expect.mode, ui.mode)
+			assert.Equal(t, expect.filter, ui.filter)
+			assert.Equal(t, expect.input, ui.input)
+			assert.Equal(t, expect.selected, ui.selected)
+			assert.Equal(t, expect.quit, ui.quit)
+		})
+	}
+}
+
+func TestTui_Press_Add(t *testing.T) {
+	ui := newTestTui(0)
+	ui.store = todo.DirectoryPersistence(t.TempDir())
+
+	for _, k := range parseKeys([]byte("athe-title\rthe-description\r")) {
+		ui.press(k)
+	}
+	ui.update(<-ui.results)
+
+	require.Len(t, ui.visible, 1)
+	assert.Equal(t, "the-title", ui.visible[0].Title)
+	assert.Equal(t, "the-description", ui.visible[0].Description)
+	assert.Equal(t, `Added "the-title"`, ui.status)
+}
+
+func TestTui_Press_Delete(t *testing.T) {
+	ui := newTestTui(0)
+	ui.store = todo.DirectoryPersistence(t.TempDir())
+	for i := 1; i <= 3; i++ {
+		_, err := ui.store.Create(todo.Todo{Title: fmt.Sprintf("todo-%d", i)})
+		require.NoError(t, err)
+	}
+	ui.load(nil, "")
+	ui.update(<-ui.results)
+	require.Len(t, ui.visible, 3)
+
+	ui.press(keyEnd)
+	ui.press("d")
+	deleting := ui.visible[2]
+
+	// a reload while confirming changes the list
+	ui.filter = "missing"
+	ui.apply("")
+	require.Len(t, ui.visible, 0)
+	footer, _ := ui.footer()
+	assert.Equal(t, fmt.Sprintf("Delete %q? (y/n)", deleting.Title), footer)
+
+	ui.press("y")
+	ui.update(<-ui.results)
+	assert.Equal(t, modeBrowse, ui.mode)
+	todos, err := ui.store.List()
+	require.NoError(t, err)
+	require.Len(t, todos, 2)
+	for _, td := range todos {
+		assert.NotEqual(t, deleting.ID, td.ID)
+	}
+}
+
+func TestTui_Details(t *testing.T) {
+	ui := newTestTui(0)
+	lines := ui.details(todo.Todo{
+		ID:          "id\x1b[2J",
+		Title:       "the\x07title",
+		Description: "the\rdescription",
+		UserID:      "u01\x1b]0;owned\x07",
+		Created:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, 40)
+
+	for _, line := range lines {
+		assert.Equal(t, sanitize(line), line)
+	}
+	assert.Equal(t, "ID:      id [2J", lines[0])
+	assert.Equal(t, "User:    u01 ]0;owned ", lines[2])
+}
+
+// newTestTui creates a tui with a screen of 80x5, which shows 3 todos at once,
+// and the todos id-1, id-2, .. with the descriptions odd and even
+func newTestTui(count int) *tui {
+	ui := &tui{width: 80, height: 5, results: make(chan tuiResult)}
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 1; i <= count; i++ {
+		description := "odd"
+		if i%2 == 0 {
+			description = "even"
+		}
+		ui.todos = append(ui.todos, todo.Todo{
+			ID:          fmt.Sprintf("id-%d", i),
+			Title:       fmt.Sprintf("todo-%d", i),
+			Description: description,
+			Created:     created.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	ui.apply("")
+	return ui
+}
@@ -0,0 +1,323 @@
+package todo
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyScope limits what an API key is permitted to do
+type APIKeyScope string
+
+const (
+	// APIKeyScopeRead permits reading requests (GET, HEAD, OPTIONS)
+	APIKeyScopeRead APIKeyScope = "read"
+
+	// APIKeyScopeWrite permits modifying requests (POST, PUT, PATCH, DELETE)
+	APIKeyScopeWrite APIKeyScope = "write"
+)
+
+// APIKey is a long-lived credential of a user, meant for machines. Only a hash
+// of the secret is kept, the secret itself is shown once on creation.
+type APIKey struct {
+	ID       string        `json:"id"`
+	UserID   string        `json:"user_id"`
+	Name     string        `json:"name"`
+	Hash     string        `json:"hash,omitempty"`
+	Scopes   []APIKeyScope `json:"scopes"`
+	Created  time.Time     `json:"created"`
+	LastUsed *time.Time    `json:"last_used,omitempty"`
+	Expires  *time.Time    `json:"expires,omitempty"`
+}
+
+// Expired returns whether the key is past it's expiry at the given time
+func (k APIKey) Expired(now time.Time) bool {
+	return k.Expires != nil && !now.Before(*k.Expires)
+}
+
+// Permits returns whether the key has a scope that allows the HTTP method
+func (k APIKey) Permits(method string) bool {
+	required := APIKeyScopeWrite
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		required = APIKeyScopeRead
+	}
+	for _, scope := range k.Scopes {
+		// writing implies reading
+		if scope == required || scope == APIKeyScopeWrite {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAPIKey creates a key for the user and returns it together with the secret,
+// which is not stored anywhere and must be handed out to the user
+func NewAPIKey(userID, name string, scopes []APIKeyScope, expires *time.Time) (APIKey, string, error) {
+	for _, scope := range scopes {
+		if scope != APIKeyScopeRead && scope != APIKeyScopeWrite {
+			return APIKey{}, "", invalidRequest("unsupported scope %q", scope)
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = []APIKeyScope{APIKeyScopeRead}
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return APIKey{}, "", err
+	}
+
+	// the secret carries the key ID so that the key can be found without
+	// comparing the hash against all stored keys
+	key := APIKey{
+		ID:      uuid.New().String(),
+		UserID:  userID,
+		Name:    name,
+		Scopes:  scopes,
+		Created: time.Now().UTC(),
+		Expires: expires,
+	}
+	secret := key.ID + "." + hex.EncodeToString(random)
+	key.Hash = hashAPIKeySecret(secret)
+
+	return key, secret, nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore is a storage for API keys
+type APIKeyStore interface {
+
+	// Create stores a new APIKey
+	Create(key APIKey) error
+
+	// Delete removes a key identified by it's ID. Returns os.ErrNotExist if not found
+	Delete(id string) error
+
+	// Get fetches a key identified by it's ID. Returns os.ErrNotExist if not found
+	Get(id string) (*APIKey, error)
+
+	// List returns all keys of a user
+	List(userID string) ([]APIKey, error)
+
+	// Update replaces an existing key. Returns os.ErrNotExist if not found
+	Update(key APIKey) error
+}
+
+// FileAPIKeyStore implements APIKeyStore with a single JSON file, which is
+// rewritten on every change
+type FileAPIKeyStore struct {
+	path string
+	keys map[string]APIKey
+	mu   sync.RWMutex
+}
+
+// NewFileAPIKeyStore loads keys from a JSON file, if it exists. An empty path
+// keeps keys in memory only.
+func NewFileAPIKeyStore(path string) (*FileAPIKeyStore, error) {
+	store := &FileAPIKeyStore{
+		path: path,
+		keys: make(map[string]APIKey),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	encoded, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+	if err = json.Unmarshal(encoded, &keys); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		store.keys[key.ID] = key
+	}
+
+	return store, nil
+}
+
+// Create adds the key and writes the file
+func (s *FileAPIKeyStore) Create(key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key.ID]; ok {
+		return fmt.Errorf("api key %s already exists", key.ID)
+	}
+	s.keys[key.ID] = key
+	return s.write()
+}
+
+// Delete removes the key and writes the file
+func (s *FileAPIKeyStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[id]; !ok {
+		return os.ErrNotExist
+	}
+	delete(s.keys, id)
+	return s.write()
+}
+
+// Get returns a copy of the key
+func (s *FileAPIKeyStore) Get(id string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &key, nil
+}
+
+// List returns all keys of the user, oldest first
+func (s *FileAPIKeyStore) List(userID string) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]APIKey, 0)
+	for _, key := range s.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Created.Equal(keys[j].Created) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	return keys, nil
+}
+
+// Update replaces the key and writes the file
+func (s *FileAPIKeyStore) Update(key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key.ID]; !ok {
+		return os.ErrNotExist
+	}
+	s.keys[key.ID] = key
+	return s.write()
+}
+
+// write must be called with the lock held
+func (s *FileAPIKeyStore) write() error {
+	if s.path == "" {
+		return nil
+	}
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	encoded, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write into a temporary file first, so a crash can't leave a half written file
+	tmp := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, encoded, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// DefaultAPIKeyLastUsedInterval is the minimum time between updates of the last
+// usage of an API key, if the APIKeyAuthentication has none
+const DefaultAPIKeyLastUsedInterval = time.Minute
+
+// APIKeyAuthentication checks API keys from either the "Authorization: Bearer <key>"
+// or the "X-API-Key: <key>" header against a store
+type APIKeyAuthentication struct {
+	Store APIKeyStore
+
+	// LastUsedInterval is the minimum time between updates of LastUsed of a key,
+	// so that stores are not written on every request. Defaults to
+	// DefaultAPIKeyLastUsedInterval.
+	LastUsedInterval time.Duration
+}
+
+// Authenticate returns the ID of the user owning the API key, if the key is known,
+// not expired and has a scope permitting the request method
+func (a APIKeyAuthentication) Authenticate(req *http.Request) (string, error) {
+	secret := apiKeyFromRequest(req)
+	if secret == "" {
+		return "", MissingCredentialsError
+	}
+
+	id := strings.SplitN(secret, ".", 2)[0]
+	key, err := a.Store.Get(id)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", InvalidCredentialsError
+	} else if err != nil {
+		return "", err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return "", InvalidCredentialsError
+	}
+
+	now := time.Now().UTC()
+	if key.Expired(now) {
+		return "", fmt.Errorf("api key %s expired: %w", key.ID, NotAllowedError)
+	} else if !key.Permits(req.Method) {
+		return "", fmt.Errorf("api key %s not scoped for %s: %w", key.ID, req.Method, NotAllowedError)
+	}
+
+	interval := a.LastUsedInterval
+	if interval <= 0 {
+		interval = DefaultAPIKeyLastUsedInterval
+	}
+	if key.LastUsed == nil || now.Sub(*key.LastUsed) >= interval {
+		key.LastUsed = &now
+		if err = a.Store.Update(*key); errors.Is(err, os.ErrNotExist) {
+			// revoked in the meantime
+			return "", NotAllowedError
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	return key.UserID, nil
+}
+
+// Challenges returns the bearer token challenge
+func (a APIKeyAuthentication) Challenges() []string {
+	return []string{`Bearer realm="todo"`}
+}
+
+func apiKeyFromRequest(req *http.Request) string {
+	if key := req.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	// bearer tokens which don't look like "<id>.<secret>" are meant for other
+	// schemes, like the JWTs of OIDCAuthentication
+	if token := bearerToken(req); strings.Count(token, ".") == 1 {
+		return token
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package todo_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestAPIKeyAuthentication_Authenticate(t *testing.T) {
+	store, err := todo.NewFileAPIKeyStore("")
+	require.NoError(t, err)
+
+	past := time.Now().Add(-time.Hour)
+	readKey, readSecret := createTestAPIKey(t, store, "u01", []todo.APIKeyScope{todo.APIKeyScopeRead}, nil)
+	_, writeSecret := createTestAPIKey(t, store, "u02", []todo.APIKeyScope{todo.APIKeyScopeWrite}, nil)
+	_, expiredSecret := createTestAPIKey(t, store, "u03", []todo.APIKeyScope{todo.APIKeyScopeWrite}, &past)
+
+	auth := todo.APIKeyAuthentication{Store: store}
+
+	expects := []struct {
+		name    string
+		request *http.Request
+		id      string
+		allowed bool
+	}{
+		{"missing key forbidden", createAPIKeyTestRequest(http.MethodGet, "", ""), "", false},
+		{"unknown key forbidden", createAPIKeyTestRequest(http.MethodGet, "X-API-Key", "foo.bar"), "", false},
+		{"tampered key forbidden", createAPIKeyTestRequest(http.MethodGet, "X-API-Key", readSecret+"0"), "", false},
+		{"expired key forbidden", createAPIKeyTestRequest(http.MethodGet, "X-API-Key", expiredSecret), "", false},
+		{"read key cannot write", createAPIKeyTestRequest(http.MethodPost, "X-API-Key", readSecret), "", false},
+		{"allow read key from header", createAPIKeyTestRequest(http.MethodGet, "X-API-Key", readSecret), "u01", true},
+		{"allow read key from bearer", createAPIKeyTestRequest(http.MethodGet, "Authorization", "Bearer "+readSecret), "u01", true},
+		{"allow write key to write", createAPIKeyTestRequest(http.MethodDelete, "X-API-Key", writeSecret), "u02", true},
+		{"allow write key to read", createAPIKeyTestRequest(http.MethodGet, "X-API-Key", writeSecret), "u02", true},
+	}
+
+	for _, expect := range expects {
+		t.Run(expect.name, func(t *testing.T) {
+			userID, err := auth.Authenticate(expect.request)
+			if expect.allowed {
+				assert.NoError(t, err)
+				assert.Equal(t, expect.id, userID)
+			} else {
+				assert.Error(t, err)
+				assert.True(t, errors.Is(err, todo.NotAllowedError))
+			}
+		})
+	}
+
+	used, err := store.Get(readKey.ID)
+	require.NoError(t, err)
+	require.NotNil(t, used.LastUsed)
+}
+
+func TestAPIKeyAuthentication_Authenticate_LastUsedInterval(t *testing.T) {
+	store, err := todo.NewFileAPIKeyStore("")
+	require.NoError(t, err)
+	key, secret := createTestAPIKey(t, store, "u01", []todo.APIKeyScope{todo.APIKeyScopeRead}, nil)
+	counting := &testCountingAPIKeyStore{APIKeyStore: store}
+	auth := todo.APIKeyAuthentication{Store: counting, LastUsedInterval: time.Hour}
+
+	for i := 0; i < 3; i++ {
+		_, err = auth.Authenticate(createAPIKeyTestRequest(http.MethodGet, "X-API-Key", secret))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, counting.updates)
+
+	// updated again after the interval
+	used, err := store.Get(key.ID)
+	require.NoError(t, err)
+	past := used.LastUsed.Add(-2 * time.Hour)
+	used.LastUsed = &past
+	require.NoError(t, store.Update(*used))
+	_, err = auth.Authenticate(createAPIKeyTestRequest(http.MethodGet, "X-API-Key", secret))
+	require.NoError(t, err)
+	assert.Equal(t, 2, counting.updates)
+}
+
+func TestFileAPIKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikeys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	store, err := todo.NewFileAPIKeyStore(path)
+	require.NoError(t, err)
+	key, secret := createTestAPIKey(t, store, "u01", nil, nil)
+	assert.Equal(t, []todo.APIKeyScope{todo.APIKeyScopeRead}, key.Scopes)
+
+	// only the hash must be written
+	raw, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), secret)
+	assert.Contains(t, string(raw), key.Hash)
+
+	// reloaded store knows the key
+	reloaded, err := todo.NewFileAPIKeyStore(path)
+	require.NoError(t, err)
+	keys, err := reloaded.List("u01")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, key.ID, keys[0].ID)
+
+	require.NoError(t, reloaded.Delete(key.ID))
+	_, err = reloaded.Get(key.ID)
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, os.IsNotExist(reloaded.Delete(key.ID)))
+}
+
+func createTestAPIKey(t *testing.T, store todo.APIKeyStore, userID string, scopes []todo.APIKeyScope, expires *time.Time) (todo.APIKey, string) {
+	key, secret, err := todo.NewAPIKey(userID, "test key", scopes, expires)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(secret, key.ID+"."))
+	require.NoError(t, store.Create(key))
+	return key, secret
+}
+
+func createAPIKeyTestRequest(method, header, value string) *http.Request {
+	req := httptest.NewRequest(method, "http://localhost:12345/bla", nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	return req
+}
+
+// testCountingAPIKeyStore counts the updates of keys
+type testCountingAPIKeyStore struct {
+	todo.APIKeyStore
+	updates int
+}
+
+func (s *testCountingAPIKeyStore) Update(key todo.APIKey) error {
+	s.updates++
+	return s.APIKeyStore.Update(key)
+}
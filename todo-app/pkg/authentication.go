@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Authentication permits or rejects access for HTTP requests
@@ -18,6 +20,57 @@ type Authentication interface {
 // NotAllowedError is returns when access is not permitted
 var NotAllowedError = errors.New("access not permitted")
 
+// MissingCredentialsError is returned when a request carries no credentials for
+// the authentication scheme. It wraps NotAllowedError.
+var MissingCredentialsError = fmt.Errorf("missing credentials: %w", NotAllowedError)
+
+// InvalidCredentialsError is returned when credentials don't match, like a wrong
+// password. Only these failures count as guesses. It wraps NotAllowedError.
+var InvalidCredentialsError = fmt.Errorf("invalid credentials: %w", NotAllowedError)
+
+// Challenger is implemented by Authentication implementations which can name the
+// scheme(s) they expect, for the WWW-Authenticate header of rejected requests
+type Challenger interface {
+
+	// Challenges returns WWW-Authenticate header values, like `Basic realm="todo"`
+	Challenges() []string
+}
+
+// ChainAuthentication tries multiple Authentication implementations in order. An
+// implementation which finds no credentials of it's scheme in the request (returns
+// MissingCredentialsError) hands over to the next one, any other outcome is final,
+// so that bad credentials of one scheme are not masked by a later scheme.
+type ChainAuthentication []Authentication
+
+// Authenticate returns the result of the first implementation which finds credentials
+func (a ChainAuthentication) Authenticate(req *http.Request) (string, error) {
+	for _, auth := range a {
+		userID, err := auth.Authenticate(req)
+		if errors.Is(err, MissingCredentialsError) {
+			continue
+		}
+		return userID, err
+	}
+	return "", MissingCredentialsError
+}
+
+// Challenges returns the challenges of all chained implementations
+func (a ChainAuthentication) Challenges() []string {
+	challenges := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, auth := range a {
+		if challenger, ok := auth.(Challenger); ok {
+			for _, challenge := range challenger.Challenges() {
+				if !seen[challenge] {
+					seen[challenge] = true
+					challenges = append(challenges, challenge)
+				}
+			}
+		}
+	}
+	return challenges
+}
+
 // UsersAuthentication checks credentials against a list of users
 type UsersAuthentication []User
 
@@ -26,19 +79,63 @@ type UsersAuthentication []User
 func (a UsersAuthentication) Authenticate(req *http.Request) (string, error) {
 	name, pass, ok := req.BasicAuth()
 	if !ok {
-		return "", fmt.Errorf("missing credentials: %w", NotAllowedError)
+		return "", MissingCredentialsError
 	}
 	for _, user := range a {
-		// found a user!
-		if user.Name == name && user.Password == pass {
+		// found a user! users without password, like auto provisioned ones, can't
+		// use basic auth
+		if user.Name == name {
+			if !user.CheckPassword(pass) {
+				return "", InvalidCredentialsError
+			}
 			return user.ID, nil
 		}
 	}
-	return "", NotAllowedError
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(pass))
+	return "", InvalidCredentialsError
+}
+
+// Challenges returns the HTTP basic auth challenge
+func (a UsersAuthentication) Challenges() []string {
+	return []string{`Basic realm="todo"`}
+}
+
+// StoreAuthentication checks HTTP basic auth credentials against the users of a store
+type StoreAuthentication struct {
+	Store UserStore
+}
+
+// Authenticate extracts HTTP basic auth user credentials and returns whether a user
+// in the store has a matching username and password
+func (a StoreAuthentication) Authenticate(req *http.Request) (string, error) {
+	if _, _, ok := req.BasicAuth(); !ok {
+		return "", MissingCredentialsError
+	}
+	users, err := a.Store.List()
+	if err != nil {
+		return "", err
+	}
+	return UsersAuthentication(users).Authenticate(req)
+}
+
+// Challenges returns the HTTP basic auth challenge
+func (a StoreAuthentication) Challenges() []string {
+	return UsersAuthentication{}.Challenges()
 }
 
 // LoadAuthenticationFromJSON reads a JSON file, returns an Authentication implementation
 func LoadAuthenticationFromJSON(filename string) (Authentication, error) {
+	users, err := loadUsersFromJSON(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// cast the slice of users into an Authentication implementation
+	return UsersAuthentication(users), nil
+}
+
+// loadUsersFromJSON reads a JSON file containing a list of users and validates them
+func loadUsersFromJSON(filename string) ([]User, error) {
 
 	// define a slice of users & fill it from a JSON file
 	var users []User
@@ -46,9 +143,10 @@ func LoadAuthenticationFromJSON(filename string) (Authentication, error) {
 	if err != nil {
 		return nil, err
 	} else if err = json.Unmarshal(encoded, &users); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid users file %s: %w", filename, err)
+	} else if err = validateUsers(users); err != nil {
+		return nil, fmt.Errorf("invalid users file %s: %w", filename, err)
 	}
 
-	// cast the slice of users into an Authentication implementation
-	return UsersAuthentication(users), nil
+	return users, nil
 }
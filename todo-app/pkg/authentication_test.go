@@ -1,11 +1,13 @@
 package todo_test
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	todo "github.com/ukautz/go-intro/todo-app/pkg"
 )
 
@@ -50,3 +52,46 @@ func createBasicAuthTestRequest(user, pass string) *http.Request {
 	}
 	return req
 }
+
+func TestChainAuthentication_Authenticate(t *testing.T) {
+	store, err := todo.NewFileAPIKeyStore("")
+	require.NoError(t, err)
+	_, secret := createTestAPIKey(t, store, "u03", nil, nil)
+
+	auth := todo.ChainAuthentication{
+		todo.UsersAuthentication{
+			{ID: "u01", Name: "alice", Password: "secret1"},
+		},
+		todo.APIKeyAuthentication{Store: store},
+	}
+
+	expects := []struct {
+		name    string
+		request *http.Request
+		id      string
+		err     error
+	}{
+		{"missing credentials", createBasicAuthTestRequest("", ""), "", todo.MissingCredentialsError},
+		{"bad password is not masked", createBasicAuthTestRequest("alice", "invalid"), "", todo.NotAllowedError},
+		{"bad api key", createAPIKeyTestRequest(http.MethodGet, "X-API-Key", "foo.bar"), "", todo.NotAllowedError},
+		{"allow basic auth", createBasicAuthTestRequest("alice", "secret1"), "u01", nil},
+		{"allow api key", createAPIKeyTestRequest(http.MethodGet, "X-API-Key", secret), "u03", nil},
+	}
+
+	for _, expect := range expects {
+		t.Run(expect.name, func(t *testing.T) {
+			userID, err := auth.Authenticate(expect.request)
+			if expect.err == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, expect.id, userID)
+			} else {
+				assert.True(t, errors.Is(err, expect.err), "expected %s, got %v", expect.err, err)
+				if expect.err != todo.MissingCredentialsError {
+					assert.False(t, errors.Is(err, todo.MissingCredentialsError))
+				}
+			}
+		})
+	}
+
+	assert.Equal(t, []string{`Basic realm="todo"`, `Bearer realm="todo"`}, auth.Challenges())
+}
@@ -0,0 +1,264 @@
+// Package client calls the Todo REST API server
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+const (
+	// DefaultMaxRetries is how often idempotent requests are retried
+	DefaultMaxRetries = 3
+
+	// DefaultBackoff is the wait before the first retry, which doubles with each
+	// further retry
+	DefaultBackoff = 200 * time.Millisecond
+
+	// MaxRetryAfter is the longest wait before a retry, even if the server asks
+	// for more
+	MaxRetryAfter = 30 * time.Second
+)
+
+// Client calls the Todo REST API server. Create, Get, List and Delete mirror the
+// todo.Persistence interface.
+type Client struct {
+
+	// BaseURL is the URL of the server including the route prefix, like
+	// https://todo.example.com/v1
+	BaseURL string
+
+	// HTTPClient sends the requests
+	HTTPClient *http.Client
+
+	// Username and Password are sent with HTTP basic auth, if set. Credentials in
+	// the request context, see ContextWithCredentials, are sent instead of all
+	// credentials of the Client.
+	Username string
+	Password string
+
+	// APIKey is sent in the X-API-Key header, if set
+	APIKey string
+
+	// Token is sent as bearer token, if set
+	Token string
+
+	// UserAgent is sent in the User-Agent header, if set
+	UserAgent string
+
+	// MaxRetries is how often idempotent requests are retried on network errors,
+	// rate limits and unavailable servers. Zero disables retries.
+	MaxRetries int
+
+	// Backoff is the wait before the first retry, which doubles with each further
+	// retry
+	Backoff time.Duration
+}
+
+// New creates a Client for the server at the base URL, with default retries
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: DefaultMaxRetries,
+		Backoff:    DefaultBackoff,
+	}
+}
+
+// Create creates the Todo and returns the ID assigned by the server. Only title
+// and description are sent, the server sets all other fields.
+func (c *Client) Create(ctx context.Context, td todo.Todo) (string, error) {
+	var out struct {
+		ID string `json:"id"`
+	}
+	body := map[string]string{"title": td.Title, "description": td.Description}
+	if err := c.Do(ctx, http.MethodPost, "/todo", body, &out); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
+
+// Get returns the Todo with the ID
+func (c *Client) Get(ctx context.Context, id string) (*todo.Todo, error) {
+	var out todo.Todo
+	if err := c.Do(ctx, http.MethodGet, "/todo/"+url.PathEscape(id), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// List returns all Todos
+func (c *Client) List(ctx context.Context) ([]todo.Todo, error) {
+	out := make([]todo.Todo, 0)
+	if err := c.Do(ctx, http.MethodGet, "/todo", nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Delete removes the Todo with the ID
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/todo/"+url.PathEscape(id), nil, nil)
+}
+
+// Do sends a request with the body encoded as JSON, if not nil, to the path below
+// BaseURL and decodes the JSON response into out, if not nil. Idempotent requests
+// are retried. Error statuses are returned as *StatusError. Returns
+// NoCredentialsError if the context carries empty forwarded credentials.
+func (c *Client) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	if forwarded, ok := ctx.Value(credentialsContextKey).(http.Header); ok && len(forwarded) == 0 {
+		return NoCredentialsError
+	}
+
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	retries := 0
+	if idempotent(method) {
+		retries = c.MaxRetries
+	}
+	for attempt := 0; ; attempt++ {
+		wait := c.backoff(attempt)
+		res, err := c.send(ctx, method, path, encoded)
+		if err != nil {
+			// network errors are temporary, canceled requests are not
+			if ctx.Err() != nil || attempt >= retries {
+				return err
+			}
+		} else if err = c.read(res, out); err == nil {
+			return nil
+		} else if wait, err = temporary(err, wait); wait == 0 || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("accept", "application/json")
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("user-agent", c.UserAgent)
+	}
+	if forwarded, ok := ctx.Value(credentialsContextKey).(http.Header); ok {
+		for name, values := range forwarded {
+			req.Header[name] = values
+		}
+	} else {
+		if c.Username != "" {
+			req.SetBasicAuth(c.Username, c.Password)
+		}
+		if c.APIKey != "" {
+			req.Header.Set("X-API-Key", c.APIKey)
+		}
+		if c.Token != "" {
+			req.Header.Set("authorization", "Bearer "+c.Token)
+		}
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req)
+}
+
+// read decodes the response body into out, or returns a *StatusError. The body
+// is read completely, so that the connection can be reused.
+func (c *Client) read(res *http.Response, out interface{}) error {
+	defer res.Body.Close()
+	defer io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode >= http.StatusBadRequest {
+		statusErr := &StatusError{StatusCode: res.StatusCode}
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			statusErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		var message struct {
+			Error string `json:"error"`
+		}
+		if encoded, err := ioutil.ReadAll(io.LimitReader(res.Body, 64<<10)); err == nil && json.Unmarshal(encoded, &message) == nil {
+			statusErr.Message = message.Error
+		}
+		return statusErr
+	}
+
+	if out == nil {
+		return nil
+	} else if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+// backoff returns the wait before the retry after the attempt, which doubles
+// with each attempt and has jitter, so that clients don't retry in lockstep
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.Backoff << uint(attempt)
+	if wait <= 0 {
+		return time.Millisecond
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait)))
+}
+
+// temporary returns the wait before retrying, if the server is rate limiting or
+// unavailable, or zero for permanent errors. Waits asked for by the server take
+// precedence, up to MaxRetryAfter.
+func temporary(err error, wait time.Duration) (time.Duration, error) {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return 0, err
+	}
+	switch statusErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if statusErr.RetryAfter > wait {
+			wait = statusErr.RetryAfter
+		}
+		if wait > MaxRetryAfter {
+			wait = MaxRetryAfter
+		}
+		return wait, err
+	}
+	return 0, err
+}
+
+// idempotent returns whether sending a request with the method again has no
+// further effects
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+	"github.com/ukautz/go-intro/todo-app/pkg/client"
+)
+
+func TestClient(t *testing.T) {
+	server, cleanup := createTestServer(t, nil)
+	defer cleanup()
+	c := createTestClient(server)
+	ctx := context.Background()
+
+	id, err := c.Create(ctx, todo.Todo{ID: "ignored", Title: "the-title", Description: "the-description"})
+	require.NoError(t, err)
+	assert.NotEqual(t, "ignored", id)
+
+	td, err := c.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, id, td.ID)
+	assert.Equal(t, "the-title", td.Title)
+	assert.Equal(t, "the-description", td.Description)
+	assert.Equal(t, "u01", td.UserID)
+	assert.False(t, td.Created.IsZero())
+
+	todos, err := c.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, todos, 1)
+
+	require.NoError(t, c.Delete(ctx, id))
+	todos, err = c.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, todos)
+}
+
+func TestClient_Errors(t *testing.T) {
+	server, cleanup := createTestServer(t, nil)
+	defer cleanup()
+	c := createTestClient(server)
+	ctx := context.Background()
+
+	_, err := c.Get(ctx, "missing")
+	assert.True(t, errors.Is(err, client.NotFoundError))
+	var statusErr *client.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	assert.Equal(t, "not found", statusErr.Message)
+
+	_, err = c.Create(ctx, todo.Todo{Title: " "})
+	assert.True(t, errors.Is(err, client.BadRequestError))
+	assert.Contains(t, err.Error(), "title required")
+
+	c.Password = "wrong"
+	_, err = c.List(ctx)
+	assert.True(t, errors.Is(err, client.ForbiddenError))
+
+	c.Username = ""
+	_, err = c.List(ctx)
+	assert.True(t, errors.Is(err, client.UnauthorizedError))
+
+	err = c.Do(ctx, http.MethodPut, "/todo/x", nil, nil)
+	assert.True(t, errors.Is(err, client.BadRequestError), "405 is a client error")
+}
+
+func TestClient_Retries(t *testing.T) {
+	var requests, failures int32
+	server, cleanup := createTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				rw.Header().Set("Retry-After", "0")
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	})
+	defer cleanup()
+	c := createTestClient(server)
+	ctx := context.Background()
+
+	// idempotent requests are retried
+	atomic.StoreInt32(&failures, 2)
+	_, err := c.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	// up to MaxRetries
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 10)
+	_, err = c.List(ctx)
+	assert.True(t, errors.Is(err, client.UnavailableError))
+	assert.Equal(t, int32(client.DefaultMaxRetries+1), atomic.LoadInt32(&requests))
+
+	// others not
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 1)
+	_, err = c.Create(ctx, todo.Todo{Title: "the-title"})
+	assert.True(t, errors.Is(err, client.UnavailableError))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// canceled requests end without retries
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 0)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.List(canceled)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+
+	// network errors are retried
+	server.Close()
+	start := time.Now()
+	_, err = c.List(ctx)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) >= c.Backoff/2)
+}
+
+// createTestServer starts a server with the Router and a temporary directory
+// persistence, with the optional middleware
+func createTestServer(t *testing.T, middleware todo.Middleware) (*httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "client")
+	require.NoError(t, err)
+	router := todo.Router{
+		Authentication: todo.UsersAuthentication{{ID: "u01", Name: "the-user", Password: "the-pass"}},
+		Persistence:    todo.DirectoryPersistence(dir),
+	}
+	if middleware != nil {
+		router.Middleware = []todo.Middleware{middleware}
+	}
+	server := httptest.NewServer(router.Handler())
+	return server, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func createTestClient(server *httptest.Server) *client.Client {
+	c := client.New(server.URL + "/")
+	c.Username, c.Password = "the-user", "the-pass"
+	c.Backoff = time.Millisecond
+	return c
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// BadRequestError is returned when the server rejected the request as invalid,
+	// and for client errors without their own error
+	BadRequestError = errors.New("bad request")
+
+	// UnauthorizedError is returned when the server received no credentials
+	UnauthorizedError = errors.New("unauthorized")
+
+	// ForbiddenError is returned when the credentials are wrong or not permitted
+	ForbiddenError = errors.New("forbidden")
+
+	// NotFoundError is returned when the requested resource does not exist
+	NotFoundError = errors.New("not found")
+
+	// ConflictError is returned when the resource exists already
+	ConflictError = errors.New("conflict")
+
+	// TooLargeError is returned when the request body exceeds the limit of the server
+	TooLargeError = errors.New("request body too large")
+
+	// UnsupportedMediaTypeError is returned when the server can't read the request body
+	UnsupportedMediaTypeError = errors.New("unsupported media type")
+
+	// TooManyRequestsError is returned when the client is rate limited or locked out
+	TooManyRequestsError = errors.New("too many requests")
+
+	// UnavailableError is returned when the server can't answer the request right now
+	UnavailableError = errors.New("service unavailable")
+
+	// ServerError is returned for other failures of the server
+	ServerError = errors.New("server error")
+
+	// NoCredentialsError is returned when credentials are forwarded, see
+	// ForwardCredentials, but the request had none
+	NoCredentialsError = errors.New("no credentials to forward")
+)
+
+// StatusError is returned for responses with an error status. It wraps the error
+// of the status, like NotFoundError, to be checked with errors.Is.
+type StatusError struct {
+
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+
+	// Message is the error message of the server
+	Message string
+
+	// RetryAfter is how long to wait before trying again, if the server said so
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Unwrap returns the error of the status
+func (e *StatusError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return BadRequestError
+	case http.StatusUnauthorized:
+		return UnauthorizedError
+	case http.StatusForbidden:
+		return ForbiddenError
+	case http.StatusNotFound:
+		return NotFoundError
+	case http.StatusConflict:
+		return ConflictError
+	case http.StatusRequestEntityTooLarge:
+		return TooLargeError
+	case http.StatusUnsupportedMediaType:
+		return UnsupportedMediaTypeError
+	case http.StatusTooManyRequests:
+		return TooManyRequestsError
+	case http.StatusServiceUnavailable:
+		return UnavailableError
+	}
+	if e.StatusCode < http.StatusInternalServerError {
+		return BadRequestError
+	}
+	return ServerError
+}
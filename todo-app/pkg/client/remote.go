@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+type contextKey int
+
+const credentialsContextKey contextKey = iota
+
+// ForwardedHeaders are the headers with credentials, which ForwardCredentials
+// puts into the request context
+var ForwardedHeaders = []string{"Authorization", "X-API-Key"}
+
+// ContextWithCredentials returns a context carrying the credential headers,
+// which clients send instead of their own
+func ContextWithCredentials(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, credentialsContextKey, header)
+}
+
+// ForwardCredentials is a todo.Middleware, which puts the credentials of requests
+// into their context, so that a RemotePersistence calls the remote server as the
+// user of the request. Requests without such credentials, like those of session
+// or client certificate users, fail with NoCredentialsError instead of using the
+// credentials of the Client.
+func ForwardCredentials(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		header := make(http.Header)
+		for _, name := range ForwardedHeaders {
+			if values := req.Header.Values(name); len(values) > 0 {
+				header[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		next.ServeHTTP(rw, req.WithContext(ContextWithCredentials(req.Context(), header)))
+	})
+}
+
+// RemotePersistence implements todo.Persistence and todo.ContextPersistence with
+// another Todo server. It calls the server with the credentials of the Client,
+// or those of the request context, see ForwardCredentials. The remote server
+// assigns IDs, creation times and users of Todos.
+type RemotePersistence struct {
+	Client *Client
+}
+
+// NewRemotePersistence creates a RemotePersistence for the server at the base
+// URL, which keeps connections to it open for reuse
+func NewRemotePersistence(baseURL string) *RemotePersistence {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 100
+	transport.IdleConnTimeout = 90 * time.Second
+
+	c := New(baseURL)
+	c.HTTPClient.Transport = transport
+	return &RemotePersistence{Client: c}
+}
+
+// Create stores a new Todo on the remote server and returns the ID
+func (p *RemotePersistence) Create(td todo.Todo) (string, error) {
+	return p.CreateContext(context.Background(), td)
+}
+
+// CreateContext stores a new Todo on the remote server and returns the ID
+func (p *RemotePersistence) CreateContext(ctx context.Context, td todo.Todo) (string, error) {
+	id, err := p.Client.Create(ctx, td)
+	return id, remoteError(err)
+}
+
+// Delete removes the Todo from the remote server. Returns os.ErrNotExist if not found
+func (p *RemotePersistence) Delete(id string) error {
+	return p.DeleteContext(context.Background(), id)
+}
+
+// DeleteContext removes the Todo from the remote server. Returns os.ErrNotExist
+// if not found
+func (p *RemotePersistence) DeleteContext(ctx context.Context, id string) error {
+	return remoteError(p.Client.Delete(ctx, id))
+}
+
+// Get returns the Todo from the remote server. Returns os.ErrNotExist if not found
+func (p *RemotePersistence) Get(id string) (*todo.Todo, error) {
+	return p.GetContext(context.Background(), id)
+}
+
+// GetContext returns the Todo from the remote server. Returns os.ErrNotExist if
+// not found
+func (p *RemotePersistence) GetContext(ctx context.Context, id string) (*todo.Todo, error) {
+	td, err := p.Client.Get(ctx, id)
+	return td, remoteError(err)
+}
+
+// List returns all Todos from the remote server
+func (p *RemotePersistence) List() ([]todo.Todo, error) {
+	return p.ListContext(context.Background())
+}
+
+// ListContext returns all Todos from the remote server
+func (p *RemotePersistence) ListContext(ctx context.Context) ([]todo.Todo, error) {
+	todos, err := p.Client.List(ctx)
+	return todos, remoteError(err)
+}
+
+// Close closes the idle connections to the remote server
+func (p *RemotePersistence) Close() error {
+	p.Client.HTTPClient.CloseIdleConnections()
+	return nil
+}
+
+// Reachable returns an error unless the remote server answers an authenticated
+// request with the credentials of the Client, to be used as readiness check
+func (p *RemotePersistence) Reachable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return p.Client.Do(ctx, http.MethodGet, "/todo", nil, nil)
+}
+
+// remoteError maps errors of the remote server to those of the Persistence
+// contract, so that a Router answers with the same status
+func remoteError(err error) error {
+	if err == nil {
+		return nil
+	} else if errors.Is(err, NotFoundError) {
+		return fmt.Errorf("remote: %s: %w", err, os.ErrNotExist)
+	} else if errors.Is(err, UnauthorizedError) {
+		return fmt.Errorf("remote: %s: %w", err, todo.MissingCredentialsError)
+	} else if errors.Is(err, ForbiddenError) || errors.Is(err, NoCredentialsError) {
+		return fmt.Errorf("remote: %s: %w", err, todo.NotAllowedError)
+	} else if errors.Is(err, BadRequestError) {
+		return fmt.Errorf("remote: %s: %w", err, todo.InvalidRequestError)
+	} else if errors.Is(err, TooManyRequestsError) || errors.Is(err, UnavailableError) {
+		return fmt.Errorf("remote: %s: %w", err, todo.UnavailableError)
+	}
+	return fmt.Errorf("remote: %w", err)
+}
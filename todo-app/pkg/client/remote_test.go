@@ -0,0 +1,165 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+	"github.com/ukautz/go-intro/todo-app/pkg/client"
+)
+
+var (
+	_ todo.Persistence        = &client.RemotePersistence{}
+	_ todo.ContextPersistence = &client.RemotePersistence{}
+	_ io.Closer               = &client.RemotePersistence{}
+)
+
+func TestRemotePersistence(t *testing.T) {
+	server, cleanup := createTestServer(t, nil)
+	defer cleanup()
+	p := client.NewRemotePersistence(server.URL)
+	p.Client.Username, p.Client.Password = "the-user", "the-pass"
+	p.Client.Backoff = time.Millisecond
+
+	id, err := p.Create(todo.Todo{Title: "the-title"})
+	require.NoError(t, err)
+	td, err := p.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, "the-title", td.Title)
+	todos, err := p.List()
+	require.NoError(t, err)
+	assert.Len(t, todos, 1)
+	require.NoError(t, p.Delete(id))
+
+	// the contract of the Persistence holds
+	_, err = p.Get(id)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	err = p.Delete(id)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	_, err = p.Create(todo.Todo{})
+	assert.True(t, errors.Is(err, todo.InvalidRequestError))
+
+	p.Client.Password = "wrong"
+	_, err = p.List()
+	assert.True(t, errors.Is(err, todo.NotAllowedError))
+
+	// readiness requires working credentials
+	assert.Error(t, p.Reachable())
+	p.Client.Password = "the-pass"
+	assert.NoError(t, p.Reachable())
+	server.Close()
+	assert.Error(t, p.Reachable())
+}
+
+func TestRemotePersistence_ForwardCredentials(t *testing.T) {
+	backend, cleanup := createTestServer(t, nil)
+	defer cleanup()
+
+	// the edge authenticates users itself and forwards their credentials
+	remote := client.NewRemotePersistence(backend.URL)
+	remote.Client.MaxRetries = 0
+	edge := todo.Router{
+		Authentication: todo.UsersAuthentication{
+			{ID: "edge-01", Name: "the-user", Password: "the-pass"},
+			{ID: "edge-02", Name: "edge-only", Password: "the-pass"},
+		},
+		Persistence: remote,
+		Middleware:  []todo.Middleware{client.ForwardCredentials},
+	}
+	serve := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.SetBasicAuth(user, "the-pass")
+		rec := httptest.NewRecorder()
+		edge.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("the-user", http.MethodPost, "/todo", `{"title":"the-title"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusNotFound, serve("the-user", http.MethodGet, "/todo/missing", "").Code)
+
+	// the backend assigns the user
+	credentials := httptest.NewRequest(http.MethodGet, "/", nil)
+	credentials.SetBasicAuth("the-user", "the-pass")
+	todos, err := remote.ListContext(client.ContextWithCredentials(context.Background(), credentials.Header))
+	require.NoError(t, err)
+	require.Len(t, todos, 1)
+	assert.Equal(t, "u01", todos[0].UserID)
+
+	// users unknown to the backend are rejected by it
+	assert.Equal(t, http.StatusForbidden, serve("edge-only", http.MethodGet, "/todo", "").Code)
+
+	// without forwarded or service credentials
+	_, err = remote.List()
+	assert.True(t, errors.Is(err, todo.MissingCredentialsError))
+}
+
+func TestRemotePersistence_ForwardCredentials_NothingToForward(t *testing.T) {
+	backend, cleanup := createTestServer(t, nil)
+	defer cleanup()
+
+	// the service credentials are not used for users without forwardable credentials
+	remote := client.NewRemotePersistence(backend.URL)
+	remote.Client.Username, remote.Client.Password = "the-user", "the-pass"
+	remote.Client.MaxRetries = 0
+	edge := todo.Router{
+		Authentication: testCookieAuthentication("edge-01"),
+		Persistence:    remote,
+		Middleware:     []todo.Middleware{client.ForwardCredentials},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "the-session"})
+	rec := httptest.NewRecorder()
+	edge.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	_, err := remote.ListContext(client.ContextWithCredentials(context.Background(), http.Header{}))
+	assert.True(t, errors.Is(err, todo.NotAllowedError))
+	assert.Contains(t, err.Error(), client.NoCredentialsError.Error())
+}
+
+func TestRemotePersistence_Unavailable(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(status)
+			}))
+			defer backend.Close()
+
+			remote := client.NewRemotePersistence(backend.URL)
+			remote.Client.MaxRetries = 0
+			_, err := remote.List()
+			assert.True(t, errors.Is(err, todo.UnavailableError))
+
+			edge := todo.Router{
+				Authentication: testCookieAuthentication("edge-01"),
+				Persistence:    remote,
+			}
+			req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: "the-session"})
+			rec := httptest.NewRecorder()
+			edge.Handler().ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		})
+	}
+}
+
+// testCookieAuthentication authenticates requests with a session cookie as the
+// user, like session authentication does
+type testCookieAuthentication string
+
+func (a testCookieAuthentication) Authenticate(req *http.Request) (string, error) {
+	if _, err := req.Cookie("session"); err != nil {
+		return "", todo.MissingCredentialsError
+	}
+	return string(a), nil
+}
@@ -0,0 +1,122 @@
+package todo
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// ClientCertAuthentication identifies users by their TLS client certificate, which
+// the server verified against the client CA (see tls.Config.ClientCAs)
+type ClientCertAuthentication struct {
+
+	// Mapping maps the subject common name or a subject alternative name (DNS
+	// name, email address or URI) of certificates to user IDs
+	Mapping map[string]string
+
+	// CRL rejects revoked certificates, if set
+	CRL *x509.RevocationList
+}
+
+// Authenticate returns the ID of the user the verified client certificate maps to
+func (a ClientCertAuthentication) Authenticate(req *http.Request) (string, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return "", MissingCredentialsError
+	} else if len(req.TLS.VerifiedChains) == 0 {
+		return "", fmt.Errorf("client certificate not verified: %w", NotAllowedError)
+	}
+	cert := req.TLS.PeerCertificates[0]
+
+	if a.CRL != nil {
+		if !a.CRL.NextUpdate.IsZero() && time.Now().After(a.CRL.NextUpdate) {
+			return "", fmt.Errorf("outdated CRL since %s: %w", a.CRL.NextUpdate, NotAllowedError)
+		}
+		for _, revoked := range a.CRL.RevokedCertificateEntries {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return "", fmt.Errorf("client certificate %s revoked: %w", cert.SerialNumber, NotAllowedError)
+			}
+		}
+	}
+
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	for _, name := range names {
+		if userID, ok := a.Mapping[name]; ok && name != "" {
+			return userID, nil
+		}
+	}
+
+	return "", fmt.Errorf("client certificate %q not mapped to a user: %w", cert.Subject, NotAllowedError)
+}
+
+// LoadClientCertMappingFromJSON reads a JSON object which maps certificate names
+// to user IDs
+func LoadClientCertMappingFromJSON(filename string) (map[string]string, error) {
+	encoded, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	mapping := make(map[string]string)
+	if err = json.Unmarshal(encoded, &mapping); err != nil {
+		return nil, fmt.Errorf("invalid client certificate mapping %s: %w", filename, err)
+	}
+	return mapping, nil
+}
+
+// LoadCRL reads a PEM or DER encoded certificate revocation list, which must be
+// signed by one of the CA certificates
+func LoadCRL(filename string, cas []*x509.Certificate) (*x509.RevocationList, error) {
+	encoded, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(encoded); block != nil {
+		encoded = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(encoded)
+	if err != nil {
+		return nil, err
+	}
+	for _, ca := range cas {
+		if crl.CheckSignatureFrom(ca) == nil {
+			return crl, nil
+		}
+	}
+	return nil, errors.New("CRL not signed by a client CA")
+}
+
+// LoadCertificatesFromPEM reads all certificates from a PEM file, like a CA bundle
+func LoadCertificatesFromPEM(filename string) ([]*x509.Certificate, error) {
+	encoded, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, encoded = pem.Decode(encoded)
+		if block == nil {
+			break
+		} else if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates in %s", filename)
+	}
+	return certs, nil
+}
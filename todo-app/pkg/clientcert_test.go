@@ -0,0 +1,180 @@
+package todo_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestClientCertAuthentication_Authenticate(t *testing.T) {
+	ca := newTestCA(t, "test ca")
+	otherCA := newTestCA(t, "other ca")
+	alice := ca.Issue(t, 2, "alice", "")
+	bob := ca.Issue(t, 3, "", "bob@example.com")
+	carol := ca.Issue(t, 4, "carol", "")
+	mallory := otherCA.Issue(t, 5, "alice", "")
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(4), RevocationTime: time.Now()},
+		},
+	}, ca.Cert, ca.Key)
+	require.NoError(t, err)
+	crl, err := x509.ParseRevocationList(crlDER)
+	require.NoError(t, err)
+
+	auth := todo.ClientCertAuthentication{
+		Mapping: map[string]string{
+			"alice":           "u01",
+			"bob@example.com": "u02",
+			"carol":           "u03",
+		},
+		CRL: crl,
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		userID, err := auth.Authenticate(req)
+		if err != nil {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.Write([]byte(userID))
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	srv.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+
+	expects := []struct {
+		name    string
+		cert    *tls.Certificate
+		id      string
+		allowed bool
+	}{
+		{"missing certificate forbidden", nil, "", false},
+		{"revoked certificate forbidden", carol, "", false},
+		{"allow common name", alice, "u01", true},
+		{"allow email address", bob, "u02", true},
+	}
+
+	for _, expect := range expects {
+		t.Run(expect.name, func(t *testing.T) {
+			client := srv.Client()
+			transport := client.Transport.(*http.Transport).Clone()
+			if expect.cert != nil {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{*expect.cert}
+			}
+			client.Transport = transport
+
+			res, err := client.Get(srv.URL)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			body, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			if expect.allowed {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, expect.id, string(body))
+			} else {
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+			}
+		})
+	}
+
+	// certificates of other CAs are rejected in the handshake
+	t.Run("foreign CA rejected", func(t *testing.T) {
+		client := srv.Client()
+		transport := client.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return mallory, nil
+		}
+		client.Transport = transport
+		_, err := client.Get(srv.URL)
+		assert.Error(t, err)
+	})
+}
+
+func TestClientCertAuthentication_Authenticate_OutdatedCRL(t *testing.T) {
+	ca := newTestCA(t, "test ca")
+	alice := ca.Issue(t, 2, "alice", "")
+	cert, err := x509.ParseCertificate(alice.Certificate[0])
+	require.NoError(t, err)
+
+	auth := todo.ClientCertAuthentication{
+		Mapping: map[string]string{"alice": "u01"},
+		CRL:     &x509.RevocationList{NextUpdate: time.Now().Add(-time.Minute)},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert, ca.Cert}},
+	}
+
+	// a CRL which is not renewed can't tell whether certificates were revoked
+	_, err = auth.Authenticate(req)
+	assert.True(t, errors.Is(err, todo.NotAllowedError))
+}
+
+// testCA is a throwaway certificate authority
+type testCA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{Cert: cert, Key: key}
+}
+
+// Issue creates a client certificate
+func (ca *testCA) Issue(t *testing.T, serial int64, commonName, email string) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if email != "" {
+		template.EmailAddresses = []string{email}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, key.Public(), ca.Key)
+	require.NoError(t, err)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
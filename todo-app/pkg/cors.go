@@ -0,0 +1,210 @@
+package todo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CORS allows browser front-ends on other origins to call the API, with cross
+// origin resource sharing
+type CORS struct {
+
+	// AllowedOrigins are origins like https://app.example.com, with subdomains of
+	// a fixed host like https://*.example.com, or * for any
+	AllowedOrigins []string `json:"allowed_origins"`
+
+	// AllowedMethods are the methods front-ends may use
+	AllowedMethods []string `json:"allowed_methods"`
+
+	// AllowedHeaders are the request headers front-ends may send, or * for any
+	AllowedHeaders []string `json:"allowed_headers"`
+
+	// ExposedHeaders are the response headers front-ends may read
+	ExposedHeaders []string `json:"exposed_headers"`
+
+	// AllowCredentials lets front-ends send cookies and authorization headers
+	AllowCredentials bool `json:"allow_credentials"`
+
+	// MaxAge is for how many seconds browsers may cache preflight responses
+	MaxAge int `json:"max_age"`
+}
+
+// NewCORS creates a CORS for the origins, which allows the methods and headers
+// of the API
+func NewCORS(origins ...string) *CORS {
+	return &CORS{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key", CSRFHeaderName, RequestIDHeader, TraceparentHeader},
+		ExposedHeaders: []string{RequestIDHeader, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+		MaxAge:         600,
+	}
+}
+
+// LoadCORSFromJSON reads a CORS configuration from a JSON file. Unset fields keep
+// the defaults of NewCORS.
+func LoadCORSFromJSON(filename string) (*CORS, error) {
+	encoded, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cors := NewCORS()
+	if err = json.Unmarshal(encoded, cors); err != nil {
+		return nil, fmt.Errorf("invalid CORS configuration %s: %w", filename, err)
+	}
+	return cors, cors.Validate()
+}
+
+// Validate returns an error for configurations which are invalid or unsafe
+func (c *CORS) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return errors.New("credentials can't be allowed for any origin")
+			}
+		} else if _, _, ok := wildcardOrigin(origin); !ok && strings.Contains(origin, "*") {
+			return fmt.Errorf("origin %q must have the wildcard as subdomain of a fixed host, like https://*.example.com", origin)
+		}
+	}
+	return nil
+}
+
+// wildcardHost matches the fixed host of a wildcard origin, with at least two
+// labels and an optional port
+var wildcardHost = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)+(:[0-9]+)?$`)
+
+// subdomain matches the part of an origin, which a wildcard stands for
+var subdomain = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?$`)
+
+// wildcardOrigin returns the prefix (like "https://") and suffix (like
+// ".example.com") of an origin like https://*.example.com
+func wildcardOrigin(origin string) (prefix, suffix string, ok bool) {
+	origin = strings.ToLower(origin)
+	for _, scheme := range []string{"https://*.", "http://*."} {
+		if host := strings.TrimPrefix(origin, scheme); host != origin {
+			if !wildcardHost.MatchString(host) {
+				return "", "", false
+			}
+			return scheme[:len(scheme)-2], "." + host, true
+		}
+	}
+	return "", "", false
+}
+
+// AllowsOrigin returns whether the origin matches an allowed origin
+func (c *CORS) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if prefix, suffix, ok := wildcardOrigin(allowed); ok {
+			lower := strings.ToLower(origin)
+			if len(lower) > len(prefix)+len(suffix) && strings.HasPrefix(lower, prefix) && strings.HasSuffix(lower, suffix) &&
+				subdomain.MatchString(lower[len(prefix):len(lower)-len(suffix)]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPreflight returns whether the request is a CORS preflight of a browser
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Middleware answers preflight requests, before they reach authentication, and
+// adds CORS headers to responses for allowed origins
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		rw.Header().Add("Vary", "Origin")
+
+		if isPreflight(req) {
+			c.preflight(rw, req, origin, req.Header.Get("Access-Control-Request-Method"))
+			return
+		}
+
+		if c.AllowsOrigin(origin) {
+			c.setOrigin(rw, origin)
+			if len(c.ExposedHeaders) > 0 {
+				rw.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func (c *CORS) preflight(rw http.ResponseWriter, req *http.Request, origin, method string) {
+	rw.Header().Add("Vary", "Access-Control-Request-Method")
+	rw.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	requestedHeaders := make([]string, 0)
+	for _, value := range req.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				requestedHeaders = append(requestedHeaders, header)
+			}
+		}
+	}
+	if !c.AllowsOrigin(origin) || !containsFold(c.AllowedMethods, method) || !c.allowsHeaders(requestedHeaders) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	c.setOrigin(rw, origin)
+	rw.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+	if len(requestedHeaders) > 0 {
+		rw.Header().Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+	}
+	if c.MaxAge > 0 {
+		rw.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CORS) setOrigin(rw http.ResponseWriter, origin string) {
+	if containsFold(c.AllowedOrigins, "*") && !c.AllowCredentials {
+		rw.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		rw.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		rw.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *CORS) allowsHeaders(headers []string) bool {
+	if containsFold(c.AllowedHeaders, "*") {
+		return true
+	}
+	for _, header := range headers {
+		if !containsFold(c.AllowedHeaders, header) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package todo_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestCORS_AllowsOrigin(t *testing.T) {
+	cors := todo.NewCORS("https://app.example.com", "https://*.example.org")
+	assert.True(t, cors.AllowsOrigin("https://app.example.com"))
+	assert.True(t, cors.AllowsOrigin("https://APP.example.com"))
+	assert.True(t, cors.AllowsOrigin("https://a.b.example.org"))
+	assert.False(t, cors.AllowsOrigin("https://.example.org"))
+	assert.False(t, cors.AllowsOrigin("https://evil.example.org.attacker.com"))
+	assert.False(t, cors.AllowsOrigin("http://app.example.com"))
+	assert.False(t, cors.AllowsOrigin(""))
+	assert.False(t, cors.AllowsOrigin("https://user@evil.com/.example.org"))
+	assert.False(t, cors.AllowsOrigin("https://evil.com:1.example.org"))
+
+	// wildcards are only subdomains of fixed hosts
+	for _, invalid := range []string{"https://*", "https://*.com", "https://app.*.example.org", "https://*example.org", "*.example.org"} {
+		assert.False(t, todo.NewCORS(invalid).AllowsOrigin("https://app.example.org"), invalid)
+	}
+	assert.True(t, todo.NewCORS("*").AllowsOrigin("https://any.where"))
+}
+
+func TestCORS_Validate(t *testing.T) {
+	assert.NoError(t, todo.NewCORS("https://*.example.com").Validate())
+	assert.NoError(t, todo.NewCORS("http://*.example.com:8080").Validate())
+	assert.Error(t, todo.NewCORS("https://*.*.example.com").Validate())
+	for _, invalid := range []string{"https://*", "https://*.com", "https://*.", "https://*example.com", "https://app.*.example.com", "*.example.com", "https://*.example.com/path"} {
+		cors := todo.NewCORS(invalid)
+		cors.AllowCredentials = true
+		assert.Error(t, cors.Validate(), invalid)
+	}
+	cors := todo.NewCORS("*")
+	cors.AllowCredentials = true
+	assert.Error(t, cors.Validate())
+}
+
+func TestCORS_Middleware(t *testing.T) {
+	cors := todo.NewCORS("https://app.example.com")
+	cors.AllowCredentials = true
+	handler := cors.Middleware(testNewRouter())
+
+	serve := func(method, origin string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/todo", nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// preflights are answered without credentials
+	rec := serve(http.MethodOptions, "https://app.example.com", http.Header{
+		"Access-Control-Request-Method":  {"POST"},
+		"Access-Control-Request-Headers": {"content-type, x-csrf-token"},
+	})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, POST, PUT, DELETE", rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, x-csrf-token", rec.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+	assert.Contains(t, rec.Header().Values("Vary"), "Origin")
+
+	// disallowed origins, methods and headers fail the preflight
+	for _, header := range []http.Header{
+		{"Access-Control-Request-Method": {"PATCH"}},
+		{"Access-Control-Request-Method": {"GET"}, "Access-Control-Request-Headers": {"x-custom"}},
+	} {
+		rec = serve(http.MethodOptions, "https://app.example.com", header)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+	rec = serve(http.MethodOptions, "https://evil.example.com", http.Header{"Access-Control-Request-Method": {"GET"}})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// actual requests get headers and are authenticated as usual
+	rec = serve(http.MethodGet, "https://app.example.com", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rec.Header().Get("Access-Control-Expose-Headers"), todo.RequestIDHeader)
+
+	rec = serve(http.MethodGet, "https://evil.example.com", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// OPTIONS without origin is no preflight
+	rec = serve(http.MethodOptions, "", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestLoadCORSFromJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cors")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "cors.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"allowed_origins":["*"],"max_age":60}`), 0600))
+	cors, err := todo.LoadCORSFromJSON(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*"}, cors.AllowedOrigins)
+	assert.Equal(t, 60, cors.MaxAge)
+	assert.Equal(t, todo.NewCORS().AllowedMethods, cors.AllowedMethods)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(`{"allowed_origins":["*"],"allow_credentials":true}`), 0600))
+	_, err = todo.LoadCORSFromJSON(file)
+	assert.Error(t, err)
+}
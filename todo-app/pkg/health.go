@@ -0,0 +1,86 @@
+package todo
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+)
+
+// BuildInfo describes the running build, usually injected at link time
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Health answers probes of orchestrators at /healthz (process is alive), /readyz
+// (all checks pass) and /version (build information), without authentication
+type Health struct {
+
+	// Checks must all return no error for the server to be ready
+	Checks map[string]func() error
+
+	// Build is returned at /version
+	Build BuildInfo
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h Health) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("content-type", "application/json")
+	rw.Header().Set("cache-control", "no-store")
+
+	switch req.URL.Path {
+	case "/healthz":
+		rw.Write([]byte(`{"status":"ok"}`))
+	case "/readyz":
+		h.ready(rw, req)
+	case "/version":
+		build := h.Build
+		build.GoVersion = runtime.Version()
+		writeJSON(rw, req, http.StatusOK, build)
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+		rw.Write([]byte(`{"error":"not found"}`))
+	}
+}
+
+// IsHealthPath returns whether the path is served by Health
+func IsHealthPath(path string) bool {
+	return path == "/healthz" || path == "/readyz" || path == "/version"
+}
+
+func (h Health) ready(rw http.ResponseWriter, req *http.Request) {
+	names := make([]string, 0, len(h.Checks))
+	for name := range h.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// details of failures are logged only, probes are not authenticated
+	status := http.StatusOK
+	checks := make(map[string]string)
+	for _, name := range names {
+		if err := h.Checks[name](); err != nil {
+			LoggerFromContext(req.Context()).Warn("readiness check failed", "check", name, "error", err)
+			checks[name] = "failing"
+			status = http.StatusServiceUnavailable
+		} else {
+			checks[name] = "ok"
+		}
+	}
+
+	result := "ok"
+	if status != http.StatusOK {
+		result = "failing"
+	}
+	writeJSON(rw, req, status, map[string]interface{}{"status": result, "checks": checks})
+}
+
+func writeJSON(rw http.ResponseWriter, req *http.Request, status int, data interface{}) {
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(data); err != nil {
+		LoggerFromContext(req.Context()).Warn("write response failed", "error", err)
+	}
+}
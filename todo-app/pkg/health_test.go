@@ -0,0 +1,54 @@
+package todo_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestHealth_ServeHTTP(t *testing.T) {
+	failing := errors.New("failing")
+	var usersErr error
+	health := &todo.Health{
+		Checks: map[string]func() error{
+			"storage": createTestDirectoryPersistence(t).Writable,
+			"users":   func() error { return usersErr },
+		},
+		Build: todo.BuildInfo{Version: "v1.2.3", Commit: "abcdef", Date: "2020-01-02"},
+	}
+
+	router := testNewRouter()
+	router.Health = health
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// no credentials required
+	rec := serve("/healthz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+
+	rec = serve("/readyz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok","checks":{"storage":"ok","users":"ok"}}`, rec.Body.String())
+
+	usersErr = failing
+	rec = serve("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"failing","checks":{"storage":"ok","users":"failing"}}`, rec.Body.String())
+
+	rec = serve("/version")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"version":"v1.2.3","commit":"abcdef","date":"2020-01-02","go_version":"`+runtime.Version()+`"}`, rec.Body.String())
+
+	// anything else still requires credentials
+	assert.Equal(t, http.StatusUnauthorized, serve("/todo").Code)
+}
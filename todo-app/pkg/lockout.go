@@ -0,0 +1,339 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AttemptTracker counts consecutive failed authentication attempts per key, like
+// a user name or a client IP. Implementations backed by a shared store allow
+// multiple server instances to enforce the same limits.
+type AttemptTracker interface {
+
+	// Attempt checks and reserves an attempt under one lock, so that concurrent
+	// attempts can't slip through the check before any of them failed. The check
+	// returns how long to wait, given the failures, the reserved attempts and the
+	// time of the last failure. Without wait the attempt is reserved, until it is
+	// finished by Failed or Release.
+	Attempt(key string, check func(failures, reserved int, last time.Time) time.Duration) (wait time.Duration, err error)
+
+	// Failed records a failed attempt and finishes its reservation
+	Failed(key string) error
+
+	// Release finishes the reservation of an attempt, which did not fail
+	Release(key string) error
+
+	// Failures returns the number of consecutive failures and the time of the last
+	Failures(key string) (count int, last time.Time, err error)
+
+	// Reset forgets all failures
+	Reset(key string) error
+}
+
+// TooManyAttemptsError is returned while a user name or client IP is locked out
+// after failed attempts. It wraps NotAllowedError.
+type TooManyAttemptsError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (e TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many failed attempts of %s, retry after %s", e.Key, e.RetryAfter)
+}
+
+// Unwrap returns NotAllowedError
+func (e TooManyAttemptsError) Unwrap() error {
+	return NotAllowedError
+}
+
+// ThrottledAuthentication protects an Authentication against guessing of
+// credentials. After each failed attempt the user name and the client IP must
+// wait exponentially longer before the next attempt, after too many failures
+// they are locked out for a while. A successful attempt resets the user name.
+type ThrottledAuthentication struct {
+	Authentication Authentication
+
+	// Tracker stores failed attempts
+	Tracker AttemptTracker
+
+	// MaxUserFailures is the number of failures after which a user name is locked out
+	MaxUserFailures int
+
+	// MaxIPFailures is the number of failures after which a client IP is locked out
+	MaxIPFailures int
+
+	// Backoff is the wait after the first failure, which doubles with each failure
+	Backoff time.Duration
+
+	// Lockout is how long user names and client IPs are locked out
+	Lockout time.Duration
+}
+
+// NewThrottledAuthentication wraps the Authentication with default limits
+func NewThrottledAuthentication(auth Authentication, tracker AttemptTracker) ThrottledAuthentication {
+	return ThrottledAuthentication{
+		Authentication:  auth,
+		Tracker:         tracker,
+		MaxUserFailures: 5,
+		MaxIPFailures:   20,
+		Backoff:         time.Second,
+		Lockout:         15 * time.Minute,
+	}
+}
+
+// Authenticate rejects requests of throttled user names or client IPs with a
+// TooManyAttemptsError, otherwise hands over to the wrapped Authentication and
+// tracks the outcome
+func (a ThrottledAuthentication) Authenticate(req *http.Request) (string, error) {
+	name, _, _ := req.BasicAuth()
+	var userID string
+	err := a.Attempt(req, name, func() (err error) {
+		userID, err = a.Authentication.Authenticate(req)
+		return err
+	})
+	return userID, err
+}
+
+// Attempt rejects attempts of the user name, if not empty, or the client IP of
+// the request with a TooManyAttemptsError while they are throttled. Otherwise
+// it runs the attempt, which checks credentials, and tracks the outcome. Errors
+// wrapping InvalidCredentialsError count as failures, other rejections, like of
+// API keys without the scope, don't. A success resets the user name.
+// Without Tracker, attempts are not throttled.
+func (a ThrottledAuthentication) Attempt(req *http.Request, name string, attempt func() error) error {
+	if a.Tracker == nil {
+		return attempt()
+	}
+	keys := make(map[string]int)
+	if name != "" {
+		keys[LockoutUserKey(name)] = a.MaxUserFailures
+	}
+	if ip := clientIP(req); ip != "" {
+		keys[LockoutIPKey(ip)] = a.MaxIPFailures
+	}
+
+	// report the longest wait, if user and IP are both throttled
+	reserved := make([]string, 0, len(keys))
+	release := func() error {
+		for _, key := range reserved {
+			if err := a.Tracker.Release(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var throttled *TooManyAttemptsError
+	for key, max := range keys {
+		wait, err := a.Tracker.Attempt(key, a.check(max))
+		if err != nil {
+			release()
+			return err
+		} else if wait <= 0 {
+			reserved = append(reserved, key)
+		} else if throttled == nil || wait > throttled.RetryAfter {
+			throttled = &TooManyAttemptsError{Key: key, RetryAfter: wait}
+		}
+	}
+	if throttled != nil {
+		if err := release(); err != nil {
+			return err
+		}
+		return *throttled
+	}
+
+	err := attempt()
+	if err != nil && !errors.Is(err, InvalidCredentialsError) {
+		// nothing was guessed
+		if releaseErr := release(); releaseErr != nil {
+			return releaseErr
+		}
+		return err
+	} else if err != nil {
+		for _, key := range reserved {
+			if trackErr := a.Tracker.Failed(key); trackErr != nil {
+				return trackErr
+			}
+		}
+		return err
+	}
+
+	if err = release(); err != nil {
+		return err
+	} else if name != "" {
+		if err = a.Tracker.Reset(LockoutUserKey(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Challenges returns the challenges of the wrapped Authentication
+func (a ThrottledAuthentication) Challenges() []string {
+	if challenger, ok := a.Authentication.(Challenger); ok {
+		return challenger.Challenges()
+	}
+	return nil
+}
+
+// check returns the check for AttemptTracker.Attempt of a key with max failures.
+// Reserved attempts count towards max and, after a failure, must finish before
+// the next attempt.
+func (a ThrottledAuthentication) check(max int) func(failures, reserved int, last time.Time) time.Duration {
+	return func(failures, reserved int, last time.Time) time.Duration {
+		if failures > 0 {
+			if wait := a.wait(failures, max) - time.Since(last); wait > 0 {
+				return wait
+			}
+		}
+		if failures > 0 && reserved > 0 || max > 0 && failures+reserved >= max {
+			return a.Backoff
+		}
+		return 0
+	}
+}
+
+// wait returns how long to wait after the last of count failures
+func (a ThrottledAuthentication) wait(count, max int) time.Duration {
+	if max > 0 && count >= max {
+		return a.Lockout
+	}
+	wait := a.Backoff
+	for i := 1; i < count && wait < a.Lockout; i++ {
+		wait *= 2
+	}
+	if wait > a.Lockout {
+		return a.Lockout
+	}
+	return wait
+}
+
+// LockoutUserKey returns the AttemptTracker key of a user name
+func LockoutUserKey(name string) string {
+	return "user:" + name
+}
+
+// LockoutIPKey returns the AttemptTracker key of a client IP
+func LockoutIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// MemoryAttemptTracker implements AttemptTracker in memory, for a single server
+type MemoryAttemptTracker struct {
+
+	// Expiry is after how long without failures a key is forgotten
+	Expiry time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]trackedAttempts
+}
+
+type trackedAttempts struct {
+	count    int
+	reserved int
+	last     time.Time
+}
+
+// NewMemoryAttemptTracker creates a tracker which forgets keys after the expiry
+func NewMemoryAttemptTracker(expiry time.Duration) *MemoryAttemptTracker {
+	return &MemoryAttemptTracker{
+		Expiry:   expiry,
+		attempts: make(map[string]trackedAttempts),
+	}
+}
+
+// Attempt reserves an attempt of the key, unless the check returns a wait
+func (t *MemoryAttemptTracker) Attempt(key string, check func(failures, reserved int, last time.Time) time.Duration) (time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.prune(now)
+	attempts := t.attempts[key]
+	if wait := check(attempts.count, attempts.reserved, attempts.last); wait > 0 {
+		return wait, nil
+	}
+	attempts.reserved++
+	t.attempts[key] = attempts
+	return 0, nil
+}
+
+// Failed increments the failures of the key
+func (t *MemoryAttemptTracker) Failed(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.prune(now)
+	attempts := t.attempts[key]
+	attempts.count++
+	attempts.last = now
+	if attempts.reserved > 0 {
+		attempts.reserved--
+	}
+	t.attempts[key] = attempts
+	return nil
+}
+
+// Release finishes a reserved attempt of the key
+func (t *MemoryAttemptTracker) Release(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	attempts, ok := t.attempts[key]
+	if !ok || attempts.reserved == 0 {
+		return nil
+	}
+	attempts.reserved--
+	if attempts.count == 0 && attempts.reserved == 0 {
+		delete(t.attempts, key)
+	} else {
+		t.attempts[key] = attempts
+	}
+	return nil
+}
+
+// Failures returns the failures of the key
+func (t *MemoryAttemptTracker) Failures(key string) (int, time.Time, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	attempts, ok := t.attempts[key]
+	if !ok || t.expired(attempts, time.Now()) {
+		return 0, time.Time{}, nil
+	}
+	return attempts.count, attempts.last, nil
+}
+
+// Reset forgets the failures of the key
+func (t *MemoryAttemptTracker) Reset(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if attempts := t.attempts[key]; attempts.reserved > 0 {
+		t.attempts[key] = trackedAttempts{reserved: attempts.reserved}
+	} else {
+		delete(t.attempts, key)
+	}
+	return nil
+}
+
+// expired is true for keys without reserved attempts and failures in Expiry
+func (t *MemoryAttemptTracker) expired(attempts trackedAttempts, now time.Time) bool {
+	return attempts.reserved == 0 && t.Expiry > 0 && now.Sub(attempts.last) > t.Expiry
+}
+
+// prune removes expired keys, must be called with the lock held
+func (t *MemoryAttemptTracker) prune(now time.Time) {
+	for key, attempts := range t.attempts {
+		if t.expired(attempts, now) {
+			delete(t.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package todo_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestThrottledAuthentication_Authenticate(t *testing.T) {
+	tracker := todo.NewMemoryAttemptTracker(time.Hour)
+	auth := todo.NewThrottledAuthentication(todo.UsersAuthentication{
+		{ID: "u01", Name: "alice", Password: "secret1"},
+	}, tracker)
+	auth.Backoff = 50 * time.Millisecond
+	auth.Lockout = time.Hour
+	auth.MaxUserFailures = 3
+
+	// missing credentials are no attempts
+	_, err := auth.Authenticate(createBasicAuthTestRequest("", ""))
+	require.True(t, errors.Is(err, todo.MissingCredentialsError))
+
+	// first failure
+	_, err = auth.Authenticate(createBasicAuthTestRequest("alice", "invalid"))
+	require.True(t, errors.Is(err, todo.NotAllowedError))
+
+	// even valid credentials have to wait during backoff
+	_, err = auth.Authenticate(createBasicAuthTestRequest("alice", "secret1"))
+	var tooMany todo.TooManyAttemptsError
+	require.True(t, errors.As(err, &tooMany), "expected too many attempts, got %v", err)
+	assert.True(t, tooMany.RetryAfter > 0 && tooMany.RetryAfter <= 50*time.Millisecond)
+	assert.True(t, errors.Is(err, todo.NotAllowedError))
+
+	// success after backoff resets the user
+	time.Sleep(60 * time.Millisecond)
+	userID, err := auth.Authenticate(createBasicAuthTestRequest("alice", "secret1"))
+	require.NoError(t, err)
+	assert.Equal(t, "u01", userID)
+	count, _, err := tracker.Failures(todo.LockoutUserKey("alice"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// backoff doubles, until locked out
+	require.NoError(t, tracker.Reset(todo.LockoutIPKey("192.0.2.1")))
+	for _, wait := range []time.Duration{0, 60 * time.Millisecond, 110 * time.Millisecond} {
+		time.Sleep(wait)
+		_, err = auth.Authenticate(createBasicAuthTestRequest("alice", "invalid"))
+		require.False(t, errors.As(err, &tooMany), "unexpected %v", err)
+	}
+	_, err = auth.Authenticate(createBasicAuthTestRequest("alice", "secret1"))
+	require.True(t, errors.As(err, &tooMany))
+	assert.Equal(t, todo.LockoutUserKey("alice"), tooMany.Key)
+	assert.True(t, tooMany.RetryAfter > 59*time.Minute)
+
+	// unlock
+	require.NoError(t, tracker.Reset(todo.LockoutUserKey("alice")))
+	require.NoError(t, tracker.Reset(todo.LockoutIPKey("192.0.2.1")))
+	_, err = auth.Authenticate(createBasicAuthTestRequest("alice", "secret1"))
+	assert.NoError(t, err)
+}
+
+func TestThrottledAuthentication_Authenticate_OnlyInvalidCredentials(t *testing.T) {
+	store, err := todo.NewFileAPIKeyStore("")
+	require.NoError(t, err)
+	key, secret, err := todo.NewAPIKey("u01", "read-only", []todo.APIKeyScope{todo.APIKeyScopeRead}, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Create(key))
+
+	tracker := todo.NewMemoryAttemptTracker(time.Hour)
+	auth := todo.NewThrottledAuthentication(todo.APIKeyAuthentication{Store: store}, tracker)
+	auth.MaxIPFailures = 1
+	request := func(method, secret string) *http.Request {
+		req := httptest.NewRequest(method, "/todo", nil)
+		req.Header.Set("X-API-Key", secret)
+		return req
+	}
+
+	// a valid key used outside of it's scope guessed nothing
+	for i := 0; i < 3; i++ {
+		_, err = auth.Authenticate(request(http.MethodPost, secret))
+		require.True(t, errors.Is(err, todo.NotAllowedError))
+		require.False(t, errors.Is(err, todo.InvalidCredentialsError))
+	}
+	userID, err := auth.Authenticate(request(http.MethodGet, secret))
+	require.NoError(t, err)
+	assert.Equal(t, "u01", userID)
+
+	// a wrong secret is a guess
+	_, err = auth.Authenticate(request(http.MethodGet, key.ID+".wrong"))
+	require.True(t, errors.Is(err, todo.InvalidCredentialsError))
+	_, err = auth.Authenticate(request(http.MethodGet, secret))
+	var tooMany todo.TooManyAttemptsError
+	assert.True(t, errors.As(err, &tooMany), "expected too many attempts, got %v", err)
+}
+
+func TestThrottledAuthentication_Authenticate_Concurrent(t *testing.T) {
+	release := make(chan struct{})
+	inner := &testBlockingAuthentication{release: release}
+	auth := todo.NewThrottledAuthentication(inner, todo.NewMemoryAttemptTracker(time.Hour))
+	auth.MaxUserFailures = 3
+
+	// concurrent guesses can't pass the check before any of them failed
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := auth.Authenticate(createBasicAuthTestRequest("alice", "invalid"))
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return len(errs) == 7 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	throttled := 0
+	for err := range errs {
+		var tooMany todo.TooManyAttemptsError
+		if errors.As(err, &tooMany) {
+			throttled++
+		}
+	}
+	assert.Equal(t, 7, throttled)
+	assert.Equal(t, 3, inner.Calls())
+}
+
+func TestMemoryAttemptTracker(t *testing.T) {
+	tracker := todo.NewMemoryAttemptTracker(50 * time.Millisecond)
+	require.NoError(t, tracker.Failed("foo"))
+	require.NoError(t, tracker.Failed("foo"))
+
+	count, last, err := tracker.Failures("foo")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.WithinDuration(t, time.Now(), last, time.Second)
+
+	time.Sleep(60 * time.Millisecond)
+	count, _, err = tracker.Failures("foo")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestMemoryAttemptTracker_Attempt(t *testing.T) {
+	tracker := todo.NewMemoryAttemptTracker(time.Hour)
+	check := func(failures, reserved int, last time.Time) time.Duration {
+		if failures+reserved >= 2 {
+			return time.Minute
+		}
+		return 0
+	}
+
+	wait, err := tracker.Attempt("foo", check)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+	wait, err = tracker.Attempt("foo", check)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+	wait, err = tracker.Attempt("foo", check)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, wait, "reserved attempts count")
+
+	// finished attempts free their reservation
+	require.NoError(t, tracker.Release("foo"))
+	require.NoError(t, tracker.Failed("foo"))
+	count, _, err := tracker.Failures("foo")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	wait, err = tracker.Attempt("foo", check)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// reset keeps reservations
+	require.NoError(t, tracker.Reset("foo"))
+	wait, err = tracker.Attempt("foo", check)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+	wait, err = tracker.Attempt("foo", check)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, wait)
+}
+
+// testBlockingAuthentication rejects all requests, after release is closed
+type testBlockingAuthentication struct {
+	release chan struct{}
+	mu      sync.Mutex
+	calls   int
+}
+
+func (a *testBlockingAuthentication) Authenticate(req *http.Request) (string, error) {
+	a.mu.Lock()
+	a.calls++
+	a.mu.Unlock()
+	<-a.release
+	return "", todo.InvalidCredentialsError
+}
+
+func (a *testBlockingAuthentication) Calls() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.calls
+}
@@ -0,0 +1,237 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the request and response header carrying the request ID
+const RequestIDHeader = "X-Request-ID"
+
+// ParseLogLevel returns the level of the name (debug, info, warn or error)
+func ParseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// Log formats
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// redacted replaces values of fields and query parameters with credentials
+const redacted = "[REDACTED]"
+
+// sensitiveKey matches names of fields and query parameters with credentials
+var sensitiveKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|authorization|cookie|api_?key|credential)`)
+
+// NewLogger creates a structured logger writing messages of at least level to out,
+// one line per message in format LogFormatJSON or LogFormatText. Values of
+// attributes whose keys look like credentials (password, token, ...) are redacted.
+func NewLogger(out io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	switch format {
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(out, options)), nil
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(out, options)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// redactAttr is the slog.HandlerOptions ReplaceAttr of NewLogger, which redacts
+// credentials, writes times in UTC, levels in lower case and stringers as strings
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) == 0 {
+		switch attr.Key {
+		case slog.TimeKey:
+			if attr.Value.Kind() == slog.KindTime {
+				attr.Value = slog.TimeValue(attr.Value.Time().UTC())
+			}
+			return attr
+		case slog.LevelKey:
+			attr.Value = slog.StringValue(strings.ToLower(attr.Value.String()))
+			return attr
+		case slog.MessageKey:
+			return attr
+		}
+	}
+
+	if sensitiveKey.MatchString(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	} else if attr.Value.Kind() == slog.KindAny {
+		switch value := attr.Value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, value.Error())
+		case fmt.Stringer:
+			return slog.String(attr.Key, value.String())
+		}
+	}
+	return attr
+}
+
+type contextKey int
+
+const (
+	loggerContextKey contextKey = iota
+	requestIDContextKey
+	spanContextKey
+	pathParamsContextKey
+	routePatternContextKey
+)
+
+// ContextWithLogger returns a context carrying the logger
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// loggerOrDefault returns the logger, or the default of slog if nil
+func loggerOrDefault(logger *slog.Logger) *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
+
+// LoggerFromContext returns the logger of the context, or the default of slog
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// ContextWithRequestID returns a context carrying the request ID
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// RequestIDFromContext returns the request ID of the context, if any
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// validRequestID limits request IDs from clients, which end up in logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// LogRequests returns a Middleware which assigns each request an ID, taken from
+// the RequestIDHeader header or generated, puts it and the logger with it in the
+// request context and writes an access log message after the request is handled
+func LogRequests(l *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return logRequests(l, next)
+	}
+}
+
+func logRequests(l *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		id := req.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		rw.Header().Set(RequestIDHeader, id)
+
+		logger := l.With("request_id", id)
+		if span := SpanFromContext(req.Context()); span != nil {
+			logger = logger.With("trace_id", span.TraceID)
+		}
+		ctx := ContextWithLogger(ContextWithRequestID(req.Context(), id), logger)
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(recorder, req.WithContext(ctx))
+
+		logger.Info("request",
+			"method", req.Method,
+			"url", RedactURL(req.URL),
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote_addr", req.RemoteAddr,
+			"user_agent", req.UserAgent(),
+		)
+	})
+}
+
+// RedactURL returns the URL as string, with values of query parameters which
+// look like credentials redacted
+func RedactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	query := u.Query()
+	for key := range query {
+		if sensitiveKey.MatchString(key) {
+			query[key] = []string{redacted}
+		}
+	}
+	redactedURL := *u
+	redactedURL.RawQuery = query.Encode()
+	return redactedURL.RequestURI()
+}
+
+// LoggingPersistence logs each call to a ContextPersistence at debug level, with
+// the Logger of the context (like with the request ID) and the duration
+type LoggingPersistence struct {
+	Persistence ContextPersistence
+}
+
+// CreateContext logs ContextPersistence.CreateContext
+func (p LoggingPersistence) CreateContext(ctx context.Context, todo Todo) (string, error) {
+	start := time.Now()
+	id, err := p.Persistence.CreateContext(ctx, todo)
+	p.log(ctx, "create", id, start, err)
+	return id, err
+}
+
+// DeleteContext logs ContextPersistence.DeleteContext
+func (p LoggingPersistence) DeleteContext(ctx context.Context, id string) error {
+	start := time.Now()
+	err := p.Persistence.DeleteContext(ctx, id)
+	p.log(ctx, "delete", id, start, err)
+	return err
+}
+
+// GetContext logs ContextPersistence.GetContext
+func (p LoggingPersistence) GetContext(ctx context.Context, id string) (*Todo, error) {
+	start := time.Now()
+	todo, err := p.Persistence.GetContext(ctx, id)
+	p.log(ctx, "get", id, start, err)
+	return todo, err
+}
+
+// ListContext logs ContextPersistence.ListContext
+func (p LoggingPersistence) ListContext(ctx context.Context) ([]Todo, error) {
+	start := time.Now()
+	todos, err := p.Persistence.ListContext(ctx)
+	p.log(ctx, "list", "", start, err)
+	return todos, err
+}
+
+func (p LoggingPersistence) log(ctx context.Context, operation, id string, start time.Time, err error) {
+	logger := LoggerFromContext(ctx)
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	keyValues := []interface{}{"operation", operation, "duration_ms", float64(time.Since(start).Microseconds()) / 1000}
+	if id != "" {
+		keyValues = append(keyValues, "todo_id", id)
+	}
+	if err != nil {
+		keyValues = append(keyValues, "error", err)
+	}
+	logger.DebugContext(ctx, "persistence", keyValues...)
+}
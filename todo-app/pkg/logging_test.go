@@ -0,0 +1,127 @@
+package todo_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestNewLogger(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger, err := todo.NewLogger(out, slog.LevelInfo, todo.LogFormatJSON)
+	require.NoError(t, err)
+
+	logger.Debug("hidden")
+	logger.With("component", "test").Warn("something", "count", 3, "error", errors.New("failed"), "password", "secret1", "api_key", "key",
+		slog.Group("login", "name", "alice", "secret", "secret2"))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 1)
+	var message map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &message))
+	assert.NotEmpty(t, message["time"])
+	delete(message, "time")
+	assert.Equal(t, map[string]interface{}{
+		"level":     "warn",
+		"msg":       "something",
+		"component": "test",
+		"count":     float64(3),
+		"error":     "failed",
+		"password":  "[REDACTED]",
+		"api_key":   "[REDACTED]",
+		"login":     map[string]interface{}{"name": "alice", "secret": "[REDACTED]"},
+	}, message)
+	assert.NotContains(t, out.String(), "secret1")
+	assert.NotContains(t, out.String(), "secret2")
+
+	out.Reset()
+	logger, err = todo.NewLogger(out, slog.LevelDebug, todo.LogFormatText)
+	require.NoError(t, err)
+	logger.Debug("hello world", "user", "alice", "token", "abc")
+	assert.Regexp(t, `^time=\S+ level=debug msg="hello world" user=alice token=\[REDACTED\]\n$`, out.String())
+
+	_, err = todo.NewLogger(out, slog.LevelInfo, "xml")
+	assert.Error(t, err)
+}
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := todo.ParseLogLevel("WARN")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, level)
+	_, err = todo.ParseLogLevel("loud")
+	assert.Error(t, err)
+}
+
+func TestRedactURL(t *testing.T) {
+	u, _ := url.Parse("/todo?access_token=abc&page=2")
+	assert.Equal(t, "/todo?access_token=%5BREDACTED%5D&page=2", todo.RedactURL(u))
+	u, _ = url.Parse("/todo/01")
+	assert.Equal(t, "/todo/01", todo.RedactURL(u))
+}
+
+func TestLogRequests(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger, err := todo.NewLogger(out, slog.LevelDebug, todo.LogFormatJSON)
+	require.NoError(t, err)
+	handler := todo.LogRequests(logger)(testNewRouter())
+
+	serve := func(path, requestID string) (*httptest.ResponseRecorder, []map[string]interface{}) {
+		out.Reset()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.SetBasicAuth("the-user", "the-pass")
+		if requestID != "" {
+			req.Header.Set(todo.RequestIDHeader, requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		messages := make([]map[string]interface{}, 0)
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var message map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &message), line)
+			messages = append(messages, message)
+		}
+		return rec, messages
+	}
+
+	// request ID of the client is used in all messages, including persistence
+	rec, messages := serve("/todo/todo-01?token=abc", "req-123")
+	assert.Equal(t, "req-123", rec.Header().Get(todo.RequestIDHeader))
+	msgs := make([]string, len(messages))
+	for i, message := range messages {
+		msgs[i] = message["msg"].(string)
+		assert.Equal(t, "req-123", message["request_id"])
+	}
+	assert.Equal(t, []string{"authenticated", "persistence", "request"}, msgs)
+	assert.Equal(t, "get", messages[1]["operation"])
+	assert.Equal(t, "todo-01", messages[1]["todo_id"])
+
+	access := messages[2]
+	assert.Equal(t, "GET", access["method"])
+	assert.Equal(t, "/todo/todo-01?token=%5BREDACTED%5D", access["url"])
+	assert.Equal(t, float64(http.StatusOK), access["status"])
+	assert.Equal(t, float64(rec.Body.Len()), access["bytes"])
+	assert.Contains(t, access, "duration_ms")
+
+	// invalid or missing request IDs are replaced
+	rec, messages = serve("/todo", "bad id\nwith newline")
+	id := rec.Header().Get(todo.RequestIDHeader)
+	assert.Len(t, id, 36)
+	assert.Equal(t, id, messages[len(messages)-1]["request_id"])
+
+	// errors are logged with status
+	_, messages = serve("/todo/missing", "")
+	assert.Equal(t, "request failed", messages[2]["msg"])
+	assert.Equal(t, "error", messages[2]["level"])
+	assert.Equal(t, float64(http.StatusInternalServerError), messages[2]["status"])
+}
@@ -0,0 +1,382 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are histogram buckets in seconds, suited for request latencies
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics is a registry of counters and histograms, which it serves in the
+// Prometheus text exposition format
+type Metrics struct {
+	mu         sync.RWMutex
+	counters   map[string]*CounterVec
+	histograms map[string]*HistogramVec
+}
+
+// NewMetrics creates an empty registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters:   make(map[string]*CounterVec),
+		histograms: make(map[string]*HistogramVec),
+	}
+}
+
+// Counter returns the counter with the name, which is registered on first use
+func (m *Metrics) Counter(name, help string, labels ...string) *CounterVec {
+	m.mu.RLock()
+	counter, ok := m.counters[name]
+	m.mu.RUnlock()
+	if ok {
+		return counter
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if counter, ok := m.counters[name]; ok {
+		return counter
+	}
+	counter = &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	m.counters[name] = counter
+	return counter
+}
+
+// Histogram returns the histogram with the name, which is registered on first use
+func (m *Metrics) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	m.mu.RLock()
+	histogram, ok := m.histograms[name]
+	m.mu.RUnlock()
+	if ok {
+		return histogram
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if histogram, ok := m.histograms[name]; ok {
+		return histogram
+	}
+	histogram = &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+	m.histograms[name] = histogram
+	return histogram
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("content-type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(rw)
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format, sorted by name
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.RLock()
+	names := make([]string, 0, len(m.counters)+len(m.histograms))
+	counters := make(map[string]*CounterVec, len(m.counters))
+	histograms := make(map[string]*HistogramVec, len(m.histograms))
+	for name, counter := range m.counters {
+		names = append(names, name)
+		counters[name] = counter
+	}
+	for name, histogram := range m.histograms {
+		names = append(names, name)
+		histograms[name] = histogram
+	}
+	m.mu.RUnlock()
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, name := range names {
+		if counter, ok := counters[name]; ok {
+			counter.write(&out)
+		} else {
+			histograms[name].write(&out)
+		}
+	}
+	n, err := io.WriteString(w, out.String())
+	return int64(n), err
+}
+
+// CounterVec is a counter with labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Inc increments the counter of the label values, which must be given in the
+// order of the label names
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds to the counter of the label values
+func (c *CounterVec) Add(delta float64, values ...string) {
+	key := formatLabels(c.labels, values, "", "")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += delta
+}
+
+func (c *CounterVec) write(out *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s counter\n", c.name, escapeHelp(c.help), c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(out, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// HistogramVec is a histogram with labels
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	values []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Observe records a value for the label values, which must be given in the order
+// of the label names
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	key := formatLabels(h.labels, values, "", "")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{values: values, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			v.counts[i]++
+		}
+	}
+	v.sum += value
+	v.count++
+}
+
+// ObserveSince records the seconds elapsed since start
+func (h *HistogramVec) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *HistogramVec) write(out *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s histogram\n", h.name, escapeHelp(h.help), h.name)
+	for _, key := range keys {
+		v := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(out, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, v.values, "le", formatFloat(bound)), v.counts[i])
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, v.values, "le", "+Inf"), v.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", h.name, key, formatFloat(v.sum))
+		fmt.Fprintf(out, "%s_count%s %d\n", h.name, key, v.count)
+	}
+}
+
+// formatLabels returns labels like {name="value",...}, with an optional extra label
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name+`="`+escapeLabel(value)+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+escapeLabel(extraValue)+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// InstrumentHandler counts requests and measures their duration, labelled by
+// method, status and the route template (like /todo/{id}) returned by route
+func (m *Metrics) InstrumentHandler(next http.Handler, route func(req *http.Request) string) http.Handler {
+	requests := m.Counter("todo_http_requests_total", "Number of HTTP requests", "method", "route", "status")
+	durations := m.Histogram("todo_http_request_duration_seconds", "Duration of HTTP requests", DefaultBuckets, "method", "route")
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(recorder, req)
+
+		template := route(req)
+		requests.Inc(req.Method, template, strconv.Itoa(recorder.status))
+		durations.ObserveSince(start, req.Method, template)
+	})
+}
+
+// statusRecorder remembers the status code and size of a response, and whether
+// anything was written yet
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	bytes   int
+	written bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.written = true
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	r.written = true
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += n
+	return n, err
+}
+
+// InstrumentedAuthentication counts the results of an Authentication
+type InstrumentedAuthentication struct {
+	Authentication Authentication
+	Metrics        *Metrics
+}
+
+// Authenticate hands over to the wrapped Authentication and counts the result as
+// success, missing (credentials), failure or error
+func (a InstrumentedAuthentication) Authenticate(req *http.Request) (string, error) {
+	userID, err := a.Authentication.Authenticate(req)
+	result := "success"
+	if errors.Is(err, MissingCredentialsError) {
+		result = "missing"
+	} else if errors.Is(err, NotAllowedError) {
+		result = "failure"
+	} else if err != nil {
+		result = "error"
+	}
+	a.Metrics.Counter("todo_authentication_total", "Number of authentication attempts by result", "result").Inc(result)
+	return userID, err
+}
+
+// Challenges returns the challenges of the wrapped Authentication
+func (a InstrumentedAuthentication) Challenges() []string {
+	if challenger, ok := a.Authentication.(Challenger); ok {
+		return challenger.Challenges()
+	}
+	return nil
+}
+
+// InstrumentedPersistence measures the duration of each call to a Persistence,
+// labelled by operation and result. The context of calls is passed on, if the
+// Persistence is a ContextPersistence.
+type InstrumentedPersistence struct {
+	Persistence Persistence
+	Metrics     *Metrics
+}
+
+// Create measures Persistence.Create
+func (p InstrumentedPersistence) Create(todo Todo) (string, error) {
+	return p.CreateContext(context.Background(), todo)
+}
+
+// CreateContext measures Persistence.Create
+func (p InstrumentedPersistence) CreateContext(ctx context.Context, todo Todo) (string, error) {
+	start := time.Now()
+	id, err := AdaptPersistence(p.Persistence).CreateContext(ctx, todo)
+	p.observe("create", start, err)
+	return id, err
+}
+
+// Delete measures Persistence.Delete
+func (p InstrumentedPersistence) Delete(id string) error {
+	return p.DeleteContext(context.Background(), id)
+}
+
+// DeleteContext measures Persistence.Delete
+func (p InstrumentedPersistence) DeleteContext(ctx context.Context, id string) error {
+	start := time.Now()
+	err := AdaptPersistence(p.Persistence).DeleteContext(ctx, id)
+	p.observe("delete", start, err)
+	return err
+}
+
+// Get measures Persistence.Get
+func (p InstrumentedPersistence) Get(id string) (*Todo, error) {
+	return p.GetContext(context.Background(), id)
+}
+
+// GetContext measures Persistence.Get
+func (p InstrumentedPersistence) GetContext(ctx context.Context, id string) (*Todo, error) {
+	start := time.Now()
+	todo, err := AdaptPersistence(p.Persistence).GetContext(ctx, id)
+	p.observe("get", start, err)
+	return todo, err
+}
+
+// List measures Persistence.List
+func (p InstrumentedPersistence) List() ([]Todo, error) {
+	return p.ListContext(context.Background())
+}
+
+// ListContext measures Persistence.List
+func (p InstrumentedPersistence) ListContext(ctx context.Context) ([]Todo, error) {
+	start := time.Now()
+	todos, err := AdaptPersistence(p.Persistence).ListContext(ctx)
+	p.observe("list", start, err)
+	return todos, err
+}
+
+func (p InstrumentedPersistence) observe(operation string, start time.Time, err error) {
+	result := "ok"
+	if errors.Is(err, os.ErrNotExist) {
+		result = "not_found"
+	} else if err != nil {
+		result = "error"
+	}
+	p.Metrics.Histogram("todo_persistence_duration_seconds", "Duration of persistence operations", DefaultBuckets, "operation", "result").
+		ObserveSince(start, operation, result)
+}
@@ -0,0 +1,162 @@
+package todo_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestMetrics_WriteTo(t *testing.T) {
+	metrics := todo.NewMetrics()
+	metrics.Counter("b_total", "Some \"counter\"", "kind").Inc(`a"b`)
+	metrics.Counter("b_total", "ignored").Add(2, `a"b`)
+	metrics.Histogram("a_seconds", "Some histogram", []float64{0.1, 1}, "op").Observe(0.5, "get")
+
+	out := new(strings.Builder)
+	_, err := metrics.WriteTo(out)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP a_seconds Some histogram
+# TYPE a_seconds histogram
+a_seconds_bucket{op="get",le="0.1"} 0
+a_seconds_bucket{op="get",le="1"} 1
+a_seconds_bucket{op="get",le="+Inf"} 1
+a_seconds_sum{op="get"} 0.5
+a_seconds_count{op="get"} 1
+# HELP b_total Some "counter"
+# TYPE b_total counter
+b_total{kind="a\"b"} 3
+`, out.String())
+}
+
+func TestMetrics_WriteTo_Concurrent(t *testing.T) {
+	metrics := todo.NewMetrics()
+
+	// metrics registered while written must not race
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				metrics.Counter("c"+strconv.Itoa(j)+"_total", "counter").Inc()
+				metrics.Histogram("h"+strconv.Itoa(i)+"_seconds", "histogram", todo.DefaultBuckets).Observe(0.1)
+			}
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := metrics.WriteTo(new(strings.Builder))
+		assert.NoError(t, err)
+	}
+	wg.Wait()
+
+	out := new(strings.Builder)
+	_, err := metrics.WriteTo(out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "c99_total 4\n")
+	assert.Contains(t, out.String(), "h3_seconds_count 100\n")
+}
+
+func TestRouter_Route(t *testing.T) {
+	// routes of optional features exist only if they are configured
+	router := todo.Router{
+		Prefix:   "/v1",
+		APIKeys:  &todo.FileAPIKeyStore{},
+		Users:    &todo.JSONFileUserStore{},
+		Lockouts: todo.NewMemoryAttemptTracker(time.Minute),
+		Sessions: &todo.SessionAuthentication{},
+		Health:   &todo.Health{},
+	}
+	expect := map[string]string{
+		"/v1/todo":                  "/todo",
+		"/v1/todo/todo-01":          "/todo/{id}",
+		"/v1/apikey/123":            "/apikey/{id}",
+		"/v1/users/u01":             "/users/{id}",
+		"/v1/lockouts/ip/127.0.0.1": "/lockouts/ip/{key}",
+		"/v1/auth/login":            "/auth/login",
+		"/healthz":                  "/healthz",
+		"/v1/other":                 "unmatched",
+		"/todo":                     "unmatched",
+	}
+	for path, route := range expect {
+		assert.Equal(t, route, router.Route(httptest.NewRequest(http.MethodGet, path, nil)), path)
+	}
+	assert.Equal(t, "unmatched", todo.Router{Prefix: "/v1"}.Route(httptest.NewRequest(http.MethodGet, "/v1/apikey/123", nil)))
+}
+
+func TestRouterHandler_Route(t *testing.T) {
+	router := todo.Router{Prefix: "/v1", Health: &todo.Health{}}
+	api := router.Handler()
+	for _, path := range []string{"/v1/todo", "/v1/todo/todo-01", "/healthz", "/v1/apikey/123", "/todo"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		assert.Equal(t, router.Route(req), api.Route(req), path)
+	}
+
+	// the routes are built once, later changes of the router don't apply
+	router.APIKeys = &todo.FileAPIKeyStore{}
+	req := httptest.NewRequest(http.MethodGet, "/v1/apikey/123", nil)
+	assert.Equal(t, "/apikey/{id}", router.Route(req))
+	assert.Equal(t, "unmatched", api.Route(req))
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestMetrics_Instrumented(t *testing.T) {
+	metrics := todo.NewMetrics()
+	router := testNewRouter()
+	router.Authentication = todo.InstrumentedAuthentication{Authentication: router.Authentication, Metrics: metrics}
+	router.Persistence = todo.InstrumentedPersistence{Persistence: router.Persistence, Metrics: metrics}
+	handler := metrics.InstrumentHandler(router, router.Route)
+
+	serve := func(path, user, pass string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve("/todo/todo-01", "the-user", "the-pass")
+	serve("/todo/todo-02", "the-user", "the-pass")
+	serve("/todo/missing", "the-user", "the-pass")
+	serve("/todo", "the-user", "wrong")
+	serve("/todo", "", "")
+
+	out := new(strings.Builder)
+	metrics.WriteTo(out)
+	exposed := out.String()
+	for _, line := range []string{
+		`todo_http_requests_total{method="GET",route="/todo/{id}",status="200"} 2`,
+		`todo_http_requests_total{method="GET",route="/todo/{id}",status="500"} 1`,
+		`todo_http_requests_total{method="GET",route="/todo",status="403"} 1`,
+		`todo_http_requests_total{method="GET",route="/todo",status="401"} 1`,
+		`todo_http_request_duration_seconds_count{method="GET",route="/todo/{id}"} 3`,
+		`todo_authentication_total{result="success"} 3`,
+		`todo_authentication_total{result="failure"} 1`,
+		`todo_authentication_total{result="missing"} 1`,
+		`todo_persistence_duration_seconds_count{operation="get",result="ok"} 2`,
+		`todo_persistence_duration_seconds_count{operation="get",result="error"} 1`,
+	} {
+		assert.Contains(t, exposed, line+"\n")
+	}
+	assert.NotContains(t, exposed, "todo-01")
+}
+
+func TestInstrumentedPersistence_NotFound(t *testing.T) {
+	metrics := todo.NewMetrics()
+	store := todo.InstrumentedPersistence{Persistence: createTestDirectoryPersistence(t), Metrics: metrics}
+	_, err := store.Get("missing")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	out := new(strings.Builder)
+	metrics.WriteTo(out)
+	assert.Contains(t, out.String(), `todo_persistence_duration_seconds_count{operation="get",result="not_found"} 1`+"\n")
+}
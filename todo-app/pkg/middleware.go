@@ -0,0 +1,46 @@
+package todo
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// Middleware wraps a handler, to act before and after it, like logging or
+// authentication do
+type Middleware func(next http.Handler) http.Handler
+
+// Chain wraps the handler in the middleware, with the first being the outermost,
+// which sees requests first
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Recover turns panics of handlers into 500 responses, instead of killing the
+// connection, and logs them with the stack trace
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			} else if recovered == http.ErrAbortHandler {
+				// deliberate abort of the response, which the server handles
+				panic(recovered)
+			}
+
+			LoggerFromContext(req.Context()).Error("panic in handler",
+				"method", req.Method, "url", RedactURL(req.URL), "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+			if !recorder.written {
+				rw.Header().Set("content-type", "application/json")
+				rw.WriteHeader(http.StatusInternalServerError)
+				rw.Write([]byte(`{"error":"internal server error"}`))
+			}
+		}()
+		next.ServeHTTP(recorder, req)
+	})
+}
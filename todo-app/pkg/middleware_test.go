@@ -0,0 +1,93 @@
+package todo_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestChain(t *testing.T) {
+	order := make([]string, 0)
+	middleware := func(name string) todo.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(rw, req)
+			})
+		}
+	}
+	handler := todo.Chain(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		order = append(order, "handler")
+	}), middleware("first"), middleware("second"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, []string{"first", "second", "handler"}, order)
+}
+
+func TestRecover(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger, err := todo.NewLogger(out, slog.LevelInfo, todo.LogFormatText)
+	require.NoError(t, err)
+	serve := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(todo.ContextWithLogger(req.Context(), logger))
+		rec := httptest.NewRecorder()
+		todo.Recover(handler).ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(func(rw http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	})
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.JSONEq(t, `{"error":"internal server error"}`, rec.Body.String())
+	assert.Contains(t, out.String(), "panic=boom")
+	assert.Contains(t, out.String(), "middleware_test.go")
+
+	// responses already started are not changed
+	rec = serve(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusAccepted)
+		panic("boom")
+	})
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	// aborted responses are left to the server
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		serve(func(rw http.ResponseWriter, req *http.Request) {
+			panic(http.ErrAbortHandler)
+		})
+	})
+}
+
+func TestRouter_ServeHTTP_Middleware(t *testing.T) {
+	router := testNewRouter()
+	router.Middleware = []todo.Middleware{func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("X-Custom", "yes")
+			next.ServeHTTP(rw, req)
+		})
+	}}
+
+	// middleware sees requests before authentication
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/todo", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "yes", rec.Header().Get("X-Custom"))
+
+	// panics in middleware are recovered, too
+	router.Middleware = append(router.Middleware, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			panic("boom")
+		})
+	})
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/todo", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,419 @@
+package todo
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCAuthentication validates ID or access tokens (JWT) of an OpenID Connect
+// provider, which are sent as "Authorization: Bearer <token>". Signing keys are
+// found via discovery, cached and refetched when an unknown key ID shows up.
+type OIDCAuthentication struct {
+
+	// Issuer is the URL of the provider, as in the "iss" claim
+	Issuer string
+
+	// Audience is required in the "aud" claim. All tokens are rejected without
+	// it, or tokens the issuer made for any of it's clients would be accepted.
+	Audience string
+
+	// UserClaim names the claim which is matched against the Name of Users.
+	// Defaults to "sub".
+	UserClaim string
+
+	// Users contains the known users
+	Users UserStore
+
+	// AutoProvision accepts valid tokens of unknown users, which get a stable
+	// ID derived from issuer and claim. They are added to Users, if set.
+	AutoProvision bool
+
+	// Client is used for discovery and fetching keys. Defaults to a client with
+	// DefaultOIDCTimeout.
+	Client *http.Client
+
+	// KeyCacheDuration is how long fetched keys are used until refetched
+	KeyCacheDuration time.Duration
+
+	// MinKeyRefresh is the minimum time between two fetches of keys, so that
+	// tokens with made-up key IDs can't hammer the provider
+	MinKeyRefresh time.Duration
+
+	// Leeway is tolerated for clock skew when checking "exp" and "nbf"
+	Leeway time.Duration
+
+	mu          sync.Mutex
+	jwksURI     string
+	keys        map[string]crypto.PublicKey
+	fetched     time.Time
+	provisioned map[string]bool
+}
+
+// DefaultOIDCTimeout limits requests to the provider, if the OIDCAuthentication
+// has no Client
+const DefaultOIDCTimeout = 10 * time.Second
+
+var oidcClient = &http.Client{Timeout: DefaultOIDCTimeout}
+
+// NewOIDCAuthentication creates an OIDCAuthentication with default settings
+func NewOIDCAuthentication(issuer, audience string) *OIDCAuthentication {
+	return &OIDCAuthentication{
+		Issuer:           strings.TrimSuffix(issuer, "/"),
+		Audience:         audience,
+		UserClaim:        "sub",
+		KeyCacheDuration: time.Hour,
+		MinKeyRefresh:    10 * time.Second,
+		Leeway:           time.Minute,
+	}
+}
+
+// oidcDiscovery is the subset of the provider metadata which is needed
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// jsonWebKey is a single key of a JWKS document, RSA or EC
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Authenticate validates the bearer token and returns the ID of the user it
+// was issued for
+func (a *OIDCAuthentication) Authenticate(req *http.Request) (string, error) {
+	token := bearerToken(req)
+	if strings.Count(token, ".") != 2 {
+		// no token at all or not a JWT, which might be meant for another scheme
+		return "", MissingCredentialsError
+	} else if a.Audience == "" {
+		return "", fmt.Errorf("no audience configured: %w", NotAllowedError)
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		return "", err
+	}
+
+	userClaim := a.UserClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	name, _ := claims[userClaim].(string)
+	if name == "" {
+		return "", fmt.Errorf("token without %s claim: %w", userClaim, NotAllowedError)
+	}
+
+	if a.Users != nil {
+		user, err := FindUserByName(a.Users, name)
+		if err == nil {
+			return user.ID, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	if !a.AutoProvision {
+		return "", fmt.Errorf("unknown user %s: %w", name, NotAllowedError)
+	}
+
+	return a.provision(name)
+}
+
+// Challenges returns the bearer token challenge
+func (a *OIDCAuthentication) Challenges() []string {
+	return []string{`Bearer realm="todo"`}
+}
+
+func (a *OIDCAuthentication) provision(name string) (string, error) {
+	user := User{
+		ID:   uuid.NewSHA1(uuid.NameSpaceURL, []byte(a.Issuer+"#"+name)).String(),
+		Name: name,
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.provisioned == nil {
+		a.provisioned = make(map[string]bool)
+	}
+	if !a.provisioned[user.ID] && a.Users != nil {
+		if _, err := a.Users.Create(user); err != nil {
+			return "", err
+		}
+	}
+	a.provisioned[user.ID] = true
+
+	return user.ID, nil
+}
+
+// verify checks signature and registered claims of the token and returns all claims
+func (a *OIDCAuthentication) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %s: %w", err, NotAllowedError)
+	}
+
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature: %s: %w", err, NotAllowedError)
+	}
+	if err = verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+	if err = decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %s: %w", err, NotAllowedError)
+	}
+
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != a.Issuer {
+		return nil, fmt.Errorf("token from issuer %q: %w", iss, NotAllowedError)
+	}
+	if exp, ok := claims["exp"].(float64); !ok || now.Add(-a.Leeway).After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("token expired: %w", NotAllowedError)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token not yet valid: %w", NotAllowedError)
+	}
+	if !jwtAudienceContains(claims["aud"], a.Audience) {
+		return nil, fmt.Errorf("token not for audience %s: %w", a.Audience, NotAllowedError)
+	}
+
+	return claims, nil
+}
+
+// key returns the public key with the ID, fetching keys from the provider if the
+// cache is outdated or the key is unknown (as happens on rotation). Fetches are
+// not made while holding the lock, so that a slow provider doesn't block tokens
+// with known keys. Failed fetches count for MinKeyRefresh as well, so that an
+// unavailable provider isn't hammered.
+func (a *OIDCAuthentication) key(kid string) (crypto.PublicKey, error) {
+	a.mu.Lock()
+	now := time.Now()
+	key, known := a.keys[kid]
+	outdated := now.Sub(a.fetched) > a.KeyCacheDuration
+	if known && !outdated {
+		a.mu.Unlock()
+		return key, nil
+	}
+	refetch := outdated || now.Sub(a.fetched) >= a.MinKeyRefresh
+	if refetch {
+		a.fetched = now
+	}
+	jwksURI := a.jwksURI
+	a.mu.Unlock()
+
+	if refetch {
+		keys, jwksURI, err := a.fetchKeys(jwksURI)
+		if err != nil && !known {
+			return nil, err
+		} else if err == nil {
+			a.mu.Lock()
+			a.keys, a.jwksURI = keys, jwksURI
+			a.mu.Unlock()
+			key, known = keys[kid]
+		}
+	}
+
+	if !known {
+		return nil, fmt.Errorf("unknown signing key %q: %w", kid, NotAllowedError)
+	}
+	return key, nil
+}
+
+// fetchKeys returns the keys of the provider, and the URI they were fetched from,
+// which is discovered if empty
+func (a *OIDCAuthentication) fetchKeys(jwksURI string) (map[string]crypto.PublicKey, string, error) {
+	if jwksURI == "" {
+		var discovery oidcDiscovery
+		if err := a.getJSON(a.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, "", fmt.Errorf("discovery failed: %w", err)
+		} else if strings.TrimSuffix(discovery.Issuer, "/") != a.Issuer {
+			return nil, "", fmt.Errorf("discovery returned issuer %q, expected %q", discovery.Issuer, a.Issuer)
+		} else if discovery.JWKSURI == "" {
+			return nil, "", errors.New("discovery returned no jwks_uri")
+		}
+		jwksURI = discovery.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := a.getJSON(jwksURI, &jwks); err != nil {
+		return nil, "", fmt.Errorf("fetching keys failed: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, jwksURI, nil
+}
+
+func (a *OIDCAuthentication) getJSON(url string, data interface{}) error {
+	client := a.Client
+	if client == nil {
+		client = oidcClient
+	}
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(data)
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		// especially "none" and the symmetric HS* algorithms
+		return fmt.Errorf("unsupported token algorithm %q: %w", alg, NotAllowedError)
+	}
+	hasher := hash.New()
+	hasher.Write(signed)
+	hashed := hasher.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'R' && rsa.VerifyPKCS1v15(pub, hash, hashed, signature) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[0] == 'E' && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(pub, hashed, r, s) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("invalid token signature: %w", NotAllowedError)
+}
+
+func jwtAudienceContains(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJWTSegment(segment string, data interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, data)
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
+
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
@@ -0,0 +1,327 @@
+package todo_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestOIDCAuthentication_Authenticate(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	defer provider.Close()
+
+	auth := todo.NewOIDCAuthentication(provider.URL, "todo-api")
+	auth.Users = &testUserStore{{ID: "u01", Name: "alice"}}
+
+	now := time.Now()
+	valid := map[string]interface{}{
+		"iss": provider.URL,
+		"aud": "todo-api",
+		"sub": "alice",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := make(map[string]interface{})
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[key] = value
+		return claims
+	}
+	tampered := provider.Sign(t, valid)
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	expects := []struct {
+		name    string
+		token   string
+		id      string
+		allowed bool
+	}{
+		{"wrong issuer forbidden", provider.Sign(t, with("iss", "https://evil.example")), "", false},
+		{"wrong audience forbidden", provider.Sign(t, with("aud", "other-api")), "", false},
+		{"expired forbidden", provider.Sign(t, with("exp", now.Add(-time.Hour).Unix())), "", false},
+		{"not yet valid forbidden", provider.Sign(t, with("nbf", now.Add(time.Hour).Unix())), "", false},
+		{"unknown user forbidden", provider.Sign(t, with("sub", "mallory")), "", false},
+		{"bad signature forbidden", tampered, "", false},
+		{"allow valid token", provider.Sign(t, valid), "u01", true},
+		{"allow audience list", provider.Sign(t, with("aud", []string{"other-api", "todo-api"})), "u01", true},
+	}
+
+	for _, expect := range expects {
+		t.Run(expect.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+			req.Header.Set("Authorization", "Bearer "+expect.token)
+			userID, err := auth.Authenticate(req)
+			if expect.allowed {
+				assert.NoError(t, err)
+				assert.Equal(t, expect.id, userID)
+			} else {
+				assert.True(t, errors.Is(err, todo.NotAllowedError), "expected not allowed, got %v", err)
+				assert.False(t, errors.Is(err, todo.MissingCredentialsError))
+			}
+		})
+	}
+
+	// keys are cached
+	assert.Equal(t, 1, provider.KeyFetches())
+}
+
+func TestOIDCAuthentication_Authenticate_NotAJWT(t *testing.T) {
+	auth := todo.NewOIDCAuthentication("https://issuer.example", "")
+
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	req.Header.Set("Authorization", "Bearer some-id.some-secret")
+	_, err := auth.Authenticate(req)
+	assert.True(t, errors.Is(err, todo.MissingCredentialsError))
+}
+
+func TestOIDCAuthentication_Authenticate_NoAudience(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	defer provider.Close()
+
+	auth := todo.NewOIDCAuthentication(provider.URL, "")
+	auth.Users = &testUserStore{{ID: "u01", Name: "alice"}}
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	req.Header.Set("Authorization", "Bearer "+provider.Sign(t, map[string]interface{}{
+		"iss": provider.URL,
+		"aud": "any-client",
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}))
+
+	_, err := auth.Authenticate(req)
+	assert.True(t, errors.Is(err, todo.NotAllowedError), "expected not allowed, got %v", err)
+	assert.Equal(t, 0, provider.KeyFetches())
+}
+
+func TestOIDCAuthentication_Authenticate_KeyRotation(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	defer provider.Close()
+
+	auth := todo.NewOIDCAuthentication(provider.URL, "todo-api")
+	auth.MinKeyRefresh = 0
+	auth.Users = &testUserStore{{ID: "u01", Name: "alice"}}
+	claims := map[string]interface{}{
+		"iss": provider.URL,
+		"aud": "todo-api",
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	req.Header.Set("Authorization", "Bearer "+provider.Sign(t, claims))
+	_, err := auth.Authenticate(req)
+	require.NoError(t, err)
+
+	provider.Rotate(t)
+	req.Header.Set("Authorization", "Bearer "+provider.Sign(t, claims))
+	userID, err := auth.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "u01", userID)
+	assert.Equal(t, 2, provider.KeyFetches())
+}
+
+func TestOIDCAuthentication_Authenticate_ProviderFailure(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	defer provider.Close()
+	provider.status = http.StatusServiceUnavailable
+
+	auth := todo.NewOIDCAuthentication(provider.URL, "todo-api")
+	auth.MinKeyRefresh = time.Hour
+	auth.Users = &testUserStore{{ID: "u01", Name: "alice"}}
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	req.Header.Set("Authorization", "Bearer "+provider.Sign(t, map[string]interface{}{
+		"iss": provider.URL,
+		"aud": "todo-api",
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}))
+
+	// failed fetches are not repeated before MinKeyRefresh
+	for i := 0; i < 3; i++ {
+		_, err := auth.Authenticate(req)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 1, provider.KeyFetches())
+}
+
+func TestOIDCAuthentication_Authenticate_SlowProvider(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	defer provider.Close()
+
+	auth := todo.NewOIDCAuthentication(provider.URL, "todo-api")
+	auth.MinKeyRefresh = 0
+	auth.Users = &testUserStore{{ID: "u01", Name: "alice"}}
+	claims := map[string]interface{}{
+		"iss": provider.URL,
+		"aud": "todo-api",
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	known := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	known.Header.Set("Authorization", "Bearer "+provider.Sign(t, claims))
+	_, err := auth.Authenticate(known)
+	require.NoError(t, err)
+
+	// a token with an unknown key makes a fetch, which hangs
+	provider.mu.Lock()
+	provider.block = make(chan struct{})
+	provider.mu.Unlock()
+	defer close(provider.block)
+	provider.Rotate(t)
+	unknown := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	unknown.Header.Set("Authorization", "Bearer "+provider.Sign(t, claims))
+	go auth.Authenticate(unknown)
+	require.Eventually(t, func() bool { return provider.KeyFetches() == 2 }, time.Second, time.Millisecond)
+
+	// tokens with known keys are not blocked by it
+	done := make(chan error)
+	go func() {
+		_, err := auth.Authenticate(known)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("authentication blocked by fetch of keys")
+	}
+}
+
+func TestOIDCAuthentication_Authenticate_AutoProvision(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	defer provider.Close()
+
+	users := &testUserStore{}
+	auth := todo.NewOIDCAuthentication(provider.URL, "todo-api")
+	auth.UserClaim = "email"
+	auth.AutoProvision = true
+	auth.Users = users
+
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	req.Header.Set("Authorization", "Bearer "+provider.Sign(t, map[string]interface{}{
+		"iss":   provider.URL,
+		"aud":   "todo-api",
+		"sub":   "1234",
+		"email": "carol@example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}))
+
+	first, err := auth.Authenticate(req)
+	require.NoError(t, err)
+	second, err := auth.Authenticate(req)
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, second)
+	require.Len(t, *users, 1)
+	assert.Equal(t, todo.User{ID: first, Name: "carol@example.com"}, (*users)[0])
+}
+
+// testOIDCProvider is a minimal in-process OpenID Connect provider serving
+// discovery and keys, which signs tokens with RS256
+type testOIDCProvider struct {
+	*httptest.Server
+	mu      sync.Mutex
+	key     *rsa.PrivateKey
+	kid     int
+	fetches int
+
+	// status lets fetches of keys fail, if set
+	status int
+
+	// block holds fetches of keys until closed, if set
+	block chan struct{}
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	provider := &testOIDCProvider{}
+	provider.Rotate(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(rw).Encode(map[string]string{
+			"issuer":   provider.URL,
+			"jwks_uri": provider.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(rw http.ResponseWriter, req *http.Request) {
+		provider.mu.Lock()
+		provider.fetches++
+		status, block := provider.status, provider.block
+		provider.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+		if status != 0 {
+			rw.WriteHeader(status)
+			return
+		}
+
+		provider.mu.Lock()
+		defer provider.mu.Unlock()
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"use": "sig",
+				"kid": provider.keyID(),
+				"n":   base64.RawURLEncoding.EncodeToString(provider.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(provider.key.E)).Bytes()),
+			}},
+		})
+	})
+	provider.Server = httptest.NewServer(mux)
+	return provider
+}
+
+// Rotate replaces the signing key
+func (p *testOIDCProvider) Rotate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	p.kid++
+}
+
+// Sign creates a signed JWT with the claims
+func (p *testOIDCProvider) Sign(t *testing.T, claims map[string]interface{}) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.keyID()})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, hashed[:])
+	require.NoError(t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// KeyFetches returns how often the keys have been requested
+func (p *testOIDCProvider) KeyFetches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fetches
+}
+
+func (p *testOIDCProvider) keyID() string {
+	return fmt.Sprintf("key-%d", p.kid)
+}
@@ -0,0 +1,284 @@
+package todo
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification of the document
+// returned by Router.OpenAPI
+const OpenAPIVersion = "3.1.0"
+
+// jsonObject is a JSON object of the OpenAPI document
+type jsonObject = map[string]interface{}
+
+// OpenAPI returns the OpenAPI document describing the routes, schemas and
+// security schemes of the Router. Like Routes, it contains routes of optional
+// features only, if they are configured.
+func (r Router) OpenAPI() map[string]interface{} {
+	version := "dev"
+	if r.Health != nil && r.Health.Build.Version != "" {
+		version = r.Health.Build.Version
+	}
+
+	paths := make(jsonObject)
+	add := func(method, pattern string, operation jsonObject) {
+		item, ok := paths[pattern].(jsonObject)
+		if !ok {
+			item = make(jsonObject)
+			if parameters := openAPIPathParameters(pattern); len(parameters) > 0 {
+				item["parameters"] = parameters
+			}
+			paths[pattern] = item
+		}
+		item[strings.ToLower(method)] = operation
+	}
+
+	if r.Health != nil {
+		// responses of HEAD requests have no body
+		for method, body := range map[string]bool{http.MethodGet: true, http.MethodHead: false} {
+			schema := func(name string) string {
+				if body {
+					return name
+				}
+				return ""
+			}
+			add(method, "/healthz", openAPIPublic(openAPIOperation("Liveness of the server", "", schema("Health"))))
+			ready := openAPIPublic(openAPIOperation("Readiness of the server and it's dependencies", "", schema("Health")))
+			ready["responses"].(jsonObject)["503"] = openAPIResponse("Not ready", schema("Health"))
+			add(method, "/readyz", ready)
+			add(method, "/version", openAPIPublic(openAPIOperation("Build information of the server", "", schema("Version"))))
+		}
+	}
+	add(http.MethodGet, r.Prefix+"/openapi.json", openAPIPublic(openAPIOperation("This OpenAPI document", "", "")))
+
+	// sessions start with credentials in the body and end with the session only
+	if r.Sessions != nil {
+		add(http.MethodPost, r.Prefix+"/auth/login", openAPIPublic(openAPIOperation("Login to start a session", "Login", "Session", http.StatusForbidden)))
+		logout := openAPIOperation("Logout to end the session", "", "Empty")
+		logout["security"] = []jsonObject{{"sessionCookie": []string{}}}
+		add(http.MethodPost, r.Prefix+"/auth/logout", logout)
+	}
+
+	add(http.MethodPost, r.Prefix+"/todo", openAPIOperation("Create a Todo", "TodoCreate", "ID"))
+	add(http.MethodGet, r.Prefix+"/todo", openAPIOperation("List all Todos", "", "TodoList"))
+	add(http.MethodGet, r.Prefix+"/todo/{id}", openAPIOperation("Get a Todo", "", "Todo", http.StatusNotFound))
+	add(http.MethodDelete, r.Prefix+"/todo/{id}", openAPIOperation("Delete a Todo", "", "ID", http.StatusNotFound))
+
+	if r.APIKeys != nil {
+		add(http.MethodPost, r.Prefix+"/apikey", openAPILogin(openAPIOperation("Create an API key, the secret is only returned once", "APIKeyCreate", "APIKeyCreated")))
+		add(http.MethodGet, r.Prefix+"/apikey", openAPILogin(openAPIOperation("List the API keys of the user", "", "APIKeyList")))
+		add(http.MethodDelete, r.Prefix+"/apikey/{id}", openAPILogin(openAPIOperation("Delete an API key of the user", "", "ID", http.StatusNotFound)))
+	}
+
+	if r.Users != nil {
+		add(http.MethodPut, r.Prefix+"/me/password", openAPIOperation("Change the password of the user", "PasswordChange", "ID"))
+		add(http.MethodPost, r.Prefix+"/users", openAPIOperation("Create a user, for admins only", "UserCreate", "ID", http.StatusConflict))
+		add(http.MethodGet, r.Prefix+"/users", openAPIOperation("List all users, for admins only", "", "UserList"))
+		add(http.MethodGet, r.Prefix+"/users/{id}", openAPIOperation("Get a user, for admins only", "", "User", http.StatusNotFound))
+		add(http.MethodPut, r.Prefix+"/users/{id}", openAPIOperation("Update a user, for admins only", "UserUpdate", "User", http.StatusNotFound, http.StatusConflict))
+		add(http.MethodDelete, r.Prefix+"/users/{id}", openAPIOperation("Delete a user, for admins only", "", "ID", http.StatusNotFound))
+	}
+
+	if r.Users != nil && r.Lockouts != nil {
+		add(http.MethodDelete, r.Prefix+"/lockouts/user/{key}", openAPIOperation("Lift the lockout of a user name, for admins only", "", "Unlocked"))
+		add(http.MethodDelete, r.Prefix+"/lockouts/ip/{key}", openAPIOperation("Lift the lockout of a client IP, for admins only", "", "Unlocked"))
+	}
+
+	return jsonObject{
+		"openapi": OpenAPIVersion,
+		"info": jsonObject{
+			"title":   "Todo API",
+			"version": version,
+		},
+		"paths": paths,
+		"components": jsonObject{
+			"schemas":         openAPISchemas(),
+			"securitySchemes": openAPISecuritySchemes(),
+		},
+		"security": []jsonObject{
+			{"basicAuth": []string{}},
+			{"bearerAuth": []string{}},
+			{"apiKeyAuth": []string{}},
+			{"sessionCookie": []string{}},
+			{"mutualTLS": []string{}},
+		},
+	}
+}
+
+func (r Router) openAPI(rw http.ResponseWriter, req *http.Request) {
+	r.json(rw, req, r.OpenAPI())
+}
+
+// openAPIOperation describes an authenticated operation with the schemas of
+// request and response bodies, if any, and the statuses it fails with besides
+// those of authentication, rate limiting and invalid bodies
+func openAPIOperation(summary, request, response string, statuses ...int) jsonObject {
+	responses := jsonObject{"200": openAPIResponse("OK", response)}
+	operation := jsonObject{"summary": summary, "responses": responses}
+	if request != "" {
+		operation["requestBody"] = jsonObject{
+			"required": true,
+			"content":  jsonObject{"application/json": jsonObject{"schema": openAPIRef(request)}},
+		}
+		statuses = append(statuses, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType)
+	}
+	statuses = append(statuses, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusServiceUnavailable)
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = openAPIResponse(http.StatusText(status), "Error")
+	}
+	return operation
+}
+
+// openAPILogin limits the security requirements of the operation to logins,
+// excluding API keys
+func openAPILogin(operation jsonObject) jsonObject {
+	operation["security"] = []jsonObject{
+		{"basicAuth": []string{}},
+		{"bearerAuth": []string{}},
+		{"sessionCookie": []string{}},
+		{"mutualTLS": []string{}},
+	}
+	return operation
+}
+
+// openAPIPublic removes the security requirements and the status of missing
+// credentials from the operation
+func openAPIPublic(operation jsonObject) jsonObject {
+	operation["security"] = []jsonObject{}
+	delete(operation["responses"].(jsonObject), "401")
+	return operation
+}
+
+func openAPIResponse(description, schema string) jsonObject {
+	response := jsonObject{"description": description}
+	if schema != "" {
+		response["content"] = jsonObject{"application/json": jsonObject{"schema": openAPIRef(schema)}}
+	}
+	return response
+}
+
+func openAPIRef(schema string) jsonObject {
+	return jsonObject{"$ref": "#/components/schemas/" + schema}
+}
+
+func openAPIPathParameters(pattern string) []jsonObject {
+	parameters := make([]jsonObject, 0)
+	for _, segment := range strings.Split(pattern, "/") {
+		if isPathParam(segment) {
+			parameters = append(parameters, jsonObject{
+				"name":     segment[1 : len(segment)-1],
+				"in":       "path",
+				"required": true,
+				"schema":   jsonObject{"type": "string"},
+			})
+		}
+	}
+	return parameters
+}
+
+// openAPIObject describes a JSON object with the properties, of which the
+// required must be present
+func openAPIObject(properties jsonObject, required ...string) jsonObject {
+	object := jsonObject{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
+
+func openAPISchemas() jsonObject {
+	str := jsonObject{"type": "string"}
+	dateTime := jsonObject{"type": "string", "format": "date-time"}
+	scopes := jsonObject{"type": "array", "items": jsonObject{"type": "string", "enum": []APIKeyScope{APIKeyScopeRead, APIKeyScopeWrite}}}
+	apiKey := func(secret bool) jsonObject {
+		properties := jsonObject{
+			"id":        str,
+			"user_id":   str,
+			"name":      str,
+			"scopes":    scopes,
+			"created":   dateTime,
+			"last_used": dateTime,
+			"expires":   dateTime,
+		}
+		required := []string{"id", "user_id", "name", "scopes", "created"}
+		if secret {
+			properties["secret"] = str
+			required = append(required, "secret")
+		}
+		return openAPIObject(properties, required...)
+	}
+	user := openAPIObject(jsonObject{"id": str, "name": str, "admin": jsonObject{"type": "boolean"}}, "id", "name", "admin")
+
+	return jsonObject{
+		"Error": openAPIObject(jsonObject{"error": str}, "error"),
+		"ID":    openAPIObject(jsonObject{"id": str}, "id"),
+		"Empty": openAPIObject(jsonObject{}),
+		"Todo": openAPIObject(jsonObject{
+			"id":          str,
+			"title":       str,
+			"description": str,
+			"created":     dateTime,
+			"user_id":     str,
+		}, "id", "title", "description", "created", "user_id"),
+		"TodoList": jsonObject{"type": "array", "items": openAPIRef("Todo")},
+		"TodoCreate": openAPIObject(jsonObject{
+			"title":       jsonObject{"type": "string", "minLength": 1, "maxLength": MaxTitleLength},
+			"description": jsonObject{"type": "string", "maxLength": MaxDescriptionLength},
+		}, "title"),
+		"APIKey":        apiKey(false),
+		"APIKeyList":    jsonObject{"type": "array", "items": openAPIRef("APIKey")},
+		"APIKeyCreated": apiKey(true),
+		"APIKeyCreate": openAPIObject(jsonObject{
+			"name":    str,
+			"scopes":  scopes,
+			"expires": jsonObject{"type": []string{"string", "null"}, "format": "date-time"},
+		}),
+		"User":     user,
+		"UserList": jsonObject{"type": "array", "items": openAPIRef("User")},
+		"UserCreate": openAPIObject(jsonObject{
+			"name":     str,
+			"password": jsonObject{"type": "string", "minLength": 1},
+			"admin":    jsonObject{"type": "boolean"},
+		}, "name", "password"),
+		"UserUpdate": openAPIObject(jsonObject{
+			"name":     str,
+			"password": jsonObject{"type": "string", "minLength": 1},
+			"admin":    jsonObject{"type": "boolean"},
+		}),
+		"PasswordChange": openAPIObject(jsonObject{
+			"old_password": str,
+			"new_password": jsonObject{"type": "string", "minLength": 1},
+		}, "new_password"),
+		"Login":    openAPIObject(jsonObject{"name": str, "password": str}, "name", "password"),
+		"Session":  openAPIObject(jsonObject{"user_id": str, "csrf_token": str}, "user_id", "csrf_token"),
+		"Unlocked": openAPIObject(jsonObject{"unlocked": str}, "unlocked"),
+		"Health": openAPIObject(jsonObject{
+			"status": jsonObject{"type": "string", "enum": []string{"ok", "failing"}},
+			"checks": jsonObject{"type": "object", "additionalProperties": str},
+		}, "status"),
+		"Version": openAPIObject(jsonObject{
+			"version":    str,
+			"commit":     str,
+			"date":       str,
+			"go_version": str,
+		}, "version", "commit", "date", "go_version"),
+	}
+}
+
+func openAPISecuritySchemes() jsonObject {
+	return jsonObject{
+		"basicAuth":  jsonObject{"type": "http", "scheme": "basic"},
+		"bearerAuth": jsonObject{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+		"apiKeyAuth": jsonObject{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+		"sessionCookie": jsonObject{
+			"type":        "apiKey",
+			"in":          "cookie",
+			"name":        SessionCookieName,
+			"description": "Session of the login route, state changing requests require the " + CSRFHeaderName + " header",
+		},
+		"mutualTLS": jsonObject{"type": "mutualTLS"},
+	}
+}
@@ -0,0 +1,305 @@
+package todo_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestRouter_OpenAPI(t *testing.T) {
+	router := testNewOpenAPIRouter(t)
+	doc := testFetchOpenAPI(t, router)
+	assert.Equal(t, todo.OpenAPIVersion, doc["openapi"])
+	assert.Equal(t, "v1.2.3", doc["info"].(map[string]interface{})["version"])
+
+	// every route is documented, and nothing else
+	paths := doc["paths"].(map[string]interface{})
+	patterns := router.Routes().Patterns()
+	for pattern, methods := range patterns {
+		require.Contains(t, paths, pattern)
+		item := paths[pattern].(map[string]interface{})
+		for _, method := range methods {
+			assert.Contains(t, item, strings.ToLower(method), pattern)
+		}
+	}
+	for path, item := range paths {
+		require.Contains(t, patterns, path)
+		for method := range item.(map[string]interface{}) {
+			if method != "parameters" {
+				assert.Contains(t, patterns[path], strings.ToUpper(method), path)
+			}
+		}
+	}
+
+	// routes of features which are not configured are not documented
+	doc = testFetchOpenAPI(t, testNewRouter())
+	assert.NotContains(t, doc["paths"], "/apikey")
+	assert.Contains(t, doc["paths"], "/todo/{id}")
+}
+
+func TestRouter_OpenAPI_Responses(t *testing.T) {
+	existing := assertJSONTodoFile(t, 2)
+	defer os.Remove(existing)
+	router := testNewOpenAPIRouter(t)
+	doc := testFetchOpenAPI(t, router)
+
+	var cookies []*http.Cookie
+	var csrf string
+	serve := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if user != "" {
+			req.SetBasicAuth(user, "the-pass")
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		if csrf != "" {
+			req.Header.Set(todo.CSRFHeaderName, csrf)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		// the response is documented for the route
+		pattern := router.Routes().Pattern(req.URL.EscapedPath())
+		require.NotEmpty(t, pattern, path)
+		operation := doc["paths"].(map[string]interface{})[pattern].(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+		responses := operation["responses"].(map[string]interface{})
+		require.Contains(t, responses, strconv.Itoa(rec.Code), "%s %s", method, path)
+		response := responses[strconv.Itoa(rec.Code)].(map[string]interface{})
+		if content, ok := response["content"].(map[string]interface{}); ok {
+			var value interface{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &value), "%s %s", method, path)
+			schema := content["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+			assert.NoError(t, testValidateSchema(doc, schema, value, "$"), "%s %s: %s", method, path, rec.Body.String())
+		}
+		return rec
+	}
+	id := func(rec *httptest.ResponseRecorder) string {
+		out := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		return out["id"].(string)
+	}
+
+	serve("", http.MethodGet, "/healthz", "")
+	serve("", http.MethodGet, "/readyz", "")
+	serve("", http.MethodGet, "/version", "")
+
+	// todos
+	todoID := id(serve("the-user", http.MethodPost, "/todo", `{"title":"the-title","description":"the-description"}`))
+	defer os.Remove(filepath.Join(testPersistenceDir, todoID+".json"))
+	serve("the-user", http.MethodGet, "/todo", "")
+	serve("the-user", http.MethodGet, "/todo/"+todoID, "")
+	serve("the-user", http.MethodDelete, "/todo/"+todoID, "")
+	serve("the-user", http.MethodGet, "/todo/"+todoID, "")
+	serve("the-user", http.MethodPost, "/todo", `{"titel":"typo"}`)
+	serve("", http.MethodGet, "/todo", "")
+	serve("wrong", http.MethodGet, "/todo", "")
+
+	// API keys
+	keyID := id(serve("the-user", http.MethodPost, "/apikey", `{"name":"ci","scopes":["read"],"expires":null}`))
+	serve("the-user", http.MethodGet, "/apikey", "")
+	serve("the-user", http.MethodDelete, "/apikey/"+keyID, "")
+
+	// users and lockouts
+	userID := id(serve("admin", http.MethodPost, "/users", `{"name":"carol","password":"secret"}`))
+	serve("admin", http.MethodPost, "/users", `{"name":"carol","password":"secret"}`)
+	serve("admin", http.MethodGet, "/users", "")
+	serve("admin", http.MethodGet, "/users/"+userID, "")
+	serve("admin", http.MethodPut, "/users/"+userID, `{"admin":true}`)
+	serve("admin", http.MethodDelete, "/users/"+userID, "")
+	serve("the-user", http.MethodGet, "/users", "")
+	serve("the-user", http.MethodPut, "/me/password", `{"old_password":"the-pass","new_password":"the-pass"}`)
+	serve("admin", http.MethodDelete, "/lockouts/user/the-user", "")
+	serve("admin", http.MethodDelete, "/lockouts/ip/192.0.2.1", "")
+
+	// sessions
+	rec := serve("", http.MethodPost, "/auth/login", `{"name":"the-user","password":"the-pass"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	cookies = rec.Result().Cookies()
+	session := make(map[string]string)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &session))
+	csrf = session["csrf_token"]
+	serve("", http.MethodPost, "/auth/logout", "")
+	cookies, csrf = nil, ""
+	serve("", http.MethodPost, "/auth/logout", "")
+	serve("", http.MethodPost, "/auth/login", `{"name":"the-user","password":"wrong"}`)
+}
+
+// testNewOpenAPIRouter creates a Router with all optional features
+func testNewOpenAPIRouter(t *testing.T) todo.Router {
+	apiKeys, err := todo.NewFileAPIKeyStore("")
+	require.NoError(t, err)
+	router := testNewRouter()
+	router.Authentication = testAuthentication{"admin": "the-pass", "the-user": "the-pass"}
+	router.Persistence = createTestDirectoryPersistence(t)
+	router.APIKeys = apiKeys
+	router.Users = &testUserStore{
+		{ID: "admin", Name: "admin", Password: "the-pass", Admin: true},
+		{ID: "the-user", Name: "the-user", Password: "the-pass"},
+	}
+	router.Lockouts = todo.NewMemoryAttemptTracker(time.Hour)
+	router.Sessions = todo.NewSessionAuthentication(todo.NewMemorySessionStore())
+	router.Health = &todo.Health{Build: todo.BuildInfo{Version: "v1.2.3", Commit: "abcdef", Date: "2020-01-02"}}
+	return router
+}
+
+// testFetchOpenAPI returns the decoded OpenAPI document served by the Router
+func testFetchOpenAPI(t *testing.T, router todo.Router) map[string]interface{} {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	doc := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	return doc
+}
+
+// testValidateSchema validates the decoded JSON value against the JSON schema,
+// supporting the keywords used in the OpenAPI document only
+func testValidateSchema(doc, schema map[string]interface{}, value interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		resolved, ok := schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, ref)
+		}
+		return testValidateSchema(doc, resolved, value, path)
+	}
+
+	if types, ok := schema["type"]; ok {
+		allowed := make([]string, 0)
+		if list, ok := types.([]interface{}); ok {
+			for _, typ := range list {
+				allowed = append(allowed, typ.(string))
+			}
+		} else {
+			allowed = append(allowed, types.(string))
+		}
+		if !testSchemaTypeMatches(allowed, value) {
+			return fmt.Errorf("%s: %v is not of type %v", path, value, allowed)
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == value
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch typed := value.(type) {
+	case string:
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, typed); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+		if min, ok := schema["minLength"].(float64); ok && float64(len(typed)) < min {
+			return fmt.Errorf("%s: shorter than %v", path, min)
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range typed {
+				if err := testValidateSchema(doc, items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := typed[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing required %s", path, name)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range typed {
+			if propertySchema, ok := properties[name].(map[string]interface{}); ok {
+				if err := testValidateSchema(doc, propertySchema, property, path+"."+name); err != nil {
+					return err
+				}
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				if err := testValidateSchema(doc, additional, property, path+"."+name); err != nil {
+					return err
+				}
+			} else if schema["additionalProperties"] == false {
+				return fmt.Errorf("%s: unexpected property %s", path, name)
+			}
+		}
+	}
+	return nil
+}
+
+func testSchemaTypeMatches(allowed []string, value interface{}) bool {
+	for _, typ := range allowed {
+		switch value.(type) {
+		case nil:
+			if typ == "null" {
+				return true
+			}
+		case string:
+			if typ == "string" {
+				return true
+			}
+		case bool:
+			if typ == "boolean" {
+				return true
+			}
+		case float64:
+			if typ == "number" || typ == "integer" && value.(float64) == float64(int64(value.(float64))) {
+				return true
+			}
+		case []interface{}:
+			if typ == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if typ == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func Test_testValidateSchema(t *testing.T) {
+	doc := map[string]interface{}{"components": map[string]interface{}{"schemas": map[string]interface{}{
+		"Thing": map[string]interface{}{
+			"type":                 "object",
+			"required":             []interface{}{"id"},
+			"additionalProperties": false,
+			"properties": map[string]interface{}{
+				"id":      map[string]interface{}{"type": "string"},
+				"created": map[string]interface{}{"type": "string", "format": "date-time"},
+			},
+		},
+	}}}
+	ref := map[string]interface{}{"$ref": "#/components/schemas/Thing"}
+	decode := func(encoded string) interface{} {
+		var value interface{}
+		require.NoError(t, json.NewDecoder(bytes.NewReader([]byte(encoded))).Decode(&value))
+		return value
+	}
+
+	assert.NoError(t, testValidateSchema(doc, ref, decode(`{"id":"a","created":"2020-01-02T03:04:05Z"}`), "$"))
+	assert.Error(t, testValidateSchema(doc, ref, decode(`{"created":"2020-01-02T03:04:05Z"}`), "$"))
+	assert.Error(t, testValidateSchema(doc, ref, decode(`{"id":1}`), "$"))
+	assert.Error(t, testValidateSchema(doc, ref, decode(`{"id":"a","other":1}`), "$"))
+	assert.Error(t, testValidateSchema(doc, ref, decode(`{"id":"a","created":"yesterday"}`), "$"))
+	assert.Error(t, testValidateSchema(doc, ref, decode(`[]`), "$"))
+}
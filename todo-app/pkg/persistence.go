@@ -1,10 +1,13 @@
 package todo
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,45 +29,165 @@ type Persistence interface {
 	List() ([]Todo, error)
 }
 
-// DirectoryPersistence implements Persistence with a local file system directory
+// ContextPersistence is a storage for todos, which is canceled with the context
+// and can use request scoped values of it
+type ContextPersistence interface {
+
+	// CreateContext stores a new Todo and returns the ID
+	CreateContext(ctx context.Context, todo Todo) (string, error)
+
+	// DeleteContext removes a single Todo identified by it's ID. Returns os.ErrNotExist if not found
+	DeleteContext(ctx context.Context, id string) error
+
+	// GetContext fetches a single Todo identified by it's ID. Returns os.ErrNotExist if not found
+	GetContext(ctx context.Context, id string) (*Todo, error)
+
+	// ListContext returns all Todos
+	ListContext(ctx context.Context) ([]Todo, error)
+}
+
+// AdaptPersistence returns the Persistence as ContextPersistence. Implementations
+// which don't accept a context are only canceled before each call.
+func AdaptPersistence(p Persistence) ContextPersistence {
+	if cp, ok := p.(ContextPersistence); ok {
+		return cp
+	}
+	return persistenceAdapter{p}
+}
+
+type persistenceAdapter struct {
+	Persistence
+}
+
+func (a persistenceAdapter) CreateContext(ctx context.Context, todo Todo) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return a.Create(todo)
+}
+
+func (a persistenceAdapter) DeleteContext(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Delete(id)
+}
+
+func (a persistenceAdapter) GetContext(ctx context.Context, id string) (*Todo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.Get(id)
+}
+
+func (a persistenceAdapter) ListContext(ctx context.Context) ([]Todo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.List()
+}
+
+// DirectoryPersistence implements Persistence and ContextPersistence with a local
+// file system directory
 type DirectoryPersistence string
 
-// Create stores Todo in <directory>/<id>.json file
+// todoIDPattern matches IDs of Todos, which are safe to use in file names, like
+// generated UUIDs
+var todoIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,127}$`)
+
+// Create stores Todo in <directory>/<id>.json file, with a new ID and creation
+// time. ID and Created of the given Todo are ignored.
 func (p DirectoryPersistence) Create(todo Todo) (string, error) {
-	if todo.ID == "" {
-		todo.ID = uuid.New().String()
-		todo.Created = time.Now()
+	return p.CreateContext(context.Background(), todo)
+}
+
+// CreateContext stores Todo in <directory>/<id>.json file, with a new ID and
+// creation time
+func (p DirectoryPersistence) CreateContext(ctx context.Context, todo Todo) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	todo.ID = uuid.New().String()
+	todo.Created = time.Now().UTC()
+	path, err := p.path(todo.ID)
+	if err != nil {
+		return "", err
 	}
 	encoded, err := json.Marshal(todo)
 	if err != nil {
 		return "", err
 	}
 
-	path := p.path(todo.ID)
-	err = ioutil.WriteFile(path, encoded, 0640)
+	// write into a temporary file first, so an interrupted write can't leave a
+	// half written Todo behind
+	tmp, err := ioutil.TempFile(string(p), "."+todo.ID+".*.tmp")
 	if err != nil {
 		return "", err
 	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(encoded); err != nil {
+		tmp.Close()
+		return "", err
+	} else if err = tmp.Chmod(0640); err != nil {
+		tmp.Close()
+		return "", err
+	} else if err = tmp.Close(); err != nil {
+		return "", err
+	} else if err = os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
 
 	return todo.ID, nil
 }
 
 // Delete removes <directory>/<id>.json file
 func (p DirectoryPersistence) Delete(id string) error {
-	return os.Remove(p.path(id))
+	return p.DeleteContext(context.Background(), id)
+}
+
+// DeleteContext removes <directory>/<id>.json file
+func (p DirectoryPersistence) DeleteContext(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := p.path(id)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
 }
 
 // Get reads Todo from <directory>/<id>.json file
 func (p DirectoryPersistence) Get(id string) (*Todo, error) {
-	return p.read(p.path(id))
+	return p.GetContext(context.Background(), id)
+}
+
+// GetContext reads Todo from <directory>/<id>.json file
+func (p DirectoryPersistence) GetContext(ctx context.Context, id string) (*Todo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, err := p.path(id)
+	if err != nil {
+		return nil, err
+	}
+	return p.read(path)
 }
 
 // List reads all Todos from <id>.json files in <directory>
 func (p DirectoryPersistence) List() ([]Todo, error) {
+	return p.ListContext(context.Background())
+}
+
+// ListContext reads all Todos from <id>.json files in <directory>, until the
+// context is canceled
+func (p DirectoryPersistence) ListContext(ctx context.Context) ([]Todo, error) {
 	todos := make([]Todo, 0)
 	err := filepath.Walk(string(p), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
+		} else if err = ctx.Err(); err != nil {
+			return err
 		} else if info.IsDir() {
 			return nil
 		} else if filepath.Ext(path) != ".json" {
@@ -83,8 +206,23 @@ func (p DirectoryPersistence) List() ([]Todo, error) {
 	return todos, err
 }
 
-func (p DirectoryPersistence) path(id string) string {
-	return filepath.Join(string(p), id+".json")
+// Writable returns an error unless files can be written into <directory>
+func (p DirectoryPersistence) Writable() error {
+	tmp, err := ioutil.TempFile(string(p), ".writable.*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// path returns the file of the Todo. IDs which could point to other files, like
+// ../other, can't exist.
+func (p DirectoryPersistence) path(id string) (string, error) {
+	if !todoIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid todo id %q: %w", id, os.ErrNotExist)
+	}
+	return filepath.Join(string(p), id+".json"), nil
 }
 
 func (p DirectoryPersistence) read(path string) (*Todo, error) {
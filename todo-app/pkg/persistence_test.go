@@ -1,7 +1,9 @@
 package todo_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -38,6 +40,52 @@ func TestDirectoryPersistence_Create(t *testing.T) {
 	assert.Equal(t, "u01", td.UserID)
 	assert.Equal(t, "the-title", td.Title)
 	assert.Equal(t, "the-description", td.Description)
+
+	// no temporary files are left behind
+	leftovers, err := filepath.Glob(filepath.Join(testPersistenceDir, ".*.tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
+}
+
+func TestDirectoryPersistence_CreateIgnoresIDAndCreated(t *testing.T) {
+	existing := assertJSONTodoFile(t, 2)
+	defer os.Remove(existing)
+
+	p := createTestDirectoryPersistence(t)
+	before := time.Now().Add(-time.Second)
+	for _, givenID := range []string{"todo-02", "../escaped", "/tmp/absolute"} {
+		id, err := p.Create(todo.Todo{ID: givenID, Title: "other", Created: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)})
+		require.NoError(t, err)
+		defer os.Remove(filepath.Join(testPersistenceDir, id+".json"))
+		assert.NotEqual(t, givenID, id)
+
+		created, err := p.Get(id)
+		require.NoError(t, err)
+		assert.True(t, created.Created.After(before), "creation time is set by the persistence")
+	}
+
+	// existing Todos and files outside the directory are untouched
+	td, err := p.Get("todo-02")
+	require.NoError(t, err)
+	assert.Equal(t, "todo 02", td.Title)
+	_, err = os.Stat(filepath.Join(testPersistenceDir, "..", "escaped.json"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDirectoryPersistence_InvalidID(t *testing.T) {
+	outside := filepath.Join(testPersistenceDir, "..", "outside.json")
+	require.NoError(t, ioutil.WriteFile(outside, []byte(`{"id":"outside","title":"outside"}`), 0644))
+	defer os.Remove(outside)
+
+	p := createTestDirectoryPersistence(t)
+	for _, id := range []string{"../outside", "..", "", ".hidden", "a/b", `a\b`, strings.Repeat("a", 129)} {
+		_, err := p.Get(id)
+		assert.True(t, errors.Is(err, os.ErrNotExist), id)
+		err = p.Delete(id)
+		assert.True(t, errors.Is(err, os.ErrNotExist), id)
+	}
+	_, err := os.Stat(outside)
+	assert.NoError(t, err, "files outside the directory can't be deleted")
 }
 
 func TestDirectoryPersistence_Delete(t *testing.T) {
@@ -106,6 +154,56 @@ func TestDirectoryPersistence_List(t *testing.T) {
 	}, tds)
 }
 
+func TestDirectoryPersistence_ListContext(t *testing.T) {
+	defer os.Remove(assertJSONTodoFile(t, 1))
+	defer os.Remove(assertJSONTodoFile(t, 3))
+
+	p := createTestDirectoryPersistence(t)
+	tds, err := p.ListContext(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, tds, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.ListContext(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+	_, err = p.ListContext(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	_, err = p.GetContext(ctx, "todo-01")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestAdaptPersistence(t *testing.T) {
+
+	// context aware implementations are used as they are
+	dir := createTestDirectoryPersistence(t)
+	assert.Equal(t, dir, todo.AdaptPersistence(dir))
+
+	// others are not called after cancellation
+	legacy := testPersistence{}
+	adapted := todo.AdaptPersistence(legacy)
+	ctx, cancel := context.WithCancel(context.Background())
+	id, err := adapted.CreateContext(ctx, todo.Todo{Title: "one"})
+	require.NoError(t, err)
+	assert.Contains(t, legacy, id)
+
+	cancel()
+	_, err = adapted.CreateContext(ctx, todo.Todo{Title: "two"})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Len(t, legacy, 1)
+	_, err = adapted.ListContext(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestDirectoryPersistence_Writable(t *testing.T) {
+	assert.NoError(t, createTestDirectoryPersistence(t).Writable())
+	assert.Error(t, todo.DirectoryPersistence(filepath.Join("fixtures", "missing")).Writable())
+}
+
 var (
 	testPersistenceDir = filepath.Join("fixtures", "store")
 )
@@ -0,0 +1,208 @@
+package todo
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket, which allows Burst requests at once and refills
+// at Rate requests per second. A Rate of zero, like in the zero value, does not
+// limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited returns whether the limit does not limit
+func (l RateLimit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// ParseRateLimit parses a limit like "5:10", for 5 requests per second with a burst
+// of 10. The burst defaults to the rate, rounded up, and must be at least 1 for
+// a rate above 0.
+func ParseRateLimit(spec string) (RateLimit, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rate < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q", spec)
+	}
+	burst := int(math.Ceil(rate))
+	if len(parts) == 2 {
+		if burst, err = strconv.Atoi(parts[1]); err != nil || burst < 0 || (rate > 0 && burst < 1) {
+			return RateLimit{}, fmt.Errorf("invalid rate limit burst %q", spec)
+		}
+	}
+	return RateLimit{Rate: rate, Burst: burst}, nil
+}
+
+// ParseRouteRateLimit parses a limit for a route like "POST /todo=1:5", or for a
+// route with any method like "/todo/{id}=5:10"
+func ParseRouteRateLimit(spec string) (string, RateLimit, error) {
+	index := strings.LastIndex(spec, "=")
+	if index < 1 {
+		return "", RateLimit{}, fmt.Errorf("invalid route rate limit %q", spec)
+	}
+	limit, err := ParseRateLimit(spec[index+1:])
+	return strings.TrimSpace(spec[:index]), limit, err
+}
+
+// RateLimitResult is the state of a token bucket after taking a token
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+
+	// RetryAfter is how long until the next token, if not allowed
+	RetryAfter time.Duration
+
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// RateLimitStore keeps token buckets, like in memory or in a store shared by
+// multiple servers
+type RateLimitStore interface {
+
+	// Take takes a token from the bucket of the key, if there is one
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitedError is returned when a client exceeded a rate limit
+type RateLimitedError struct {
+	Key    string
+	Result RateLimitResult
+}
+
+func (e RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limit of %s exceeded, retry after %s", e.Key, e.Result.RetryAfter)
+}
+
+// RateLimiter limits requests with token buckets, per client IP before
+// authentication and per user after it
+type RateLimiter struct {
+	Store RateLimitStore
+
+	// IP limits requests per client IP, including those which fail authentication
+	IP RateLimit
+
+	// User limits requests per authenticated user
+	User RateLimit
+
+	// Routes replace the User limit for routes like "POST /todo" or, for any
+	// method, "/todo/{id}" (see RouterHandler.Route). Each has its own bucket.
+	Routes map[string]RateLimit
+}
+
+// LimitIP takes a token of the client IP of the request and sets the RateLimit
+// headers. Returns RateLimitedError if none is left.
+func (l *RateLimiter) LimitIP(rw http.ResponseWriter, req *http.Request) error {
+	return l.take(rw, "ip:"+clientIP(req), l.IP)
+}
+
+// LimitUser takes a token of the user for the route and sets the RateLimit
+// headers. Returns RateLimitedError if none is left.
+func (l *RateLimiter) LimitUser(rw http.ResponseWriter, req *http.Request, userID, route string) error {
+	key, limit := "user:"+userID, l.User
+	for _, name := range []string{req.Method + " " + route, route} {
+		if routeLimit, ok := l.Routes[name]; ok {
+			key, limit = key+":"+name, routeLimit
+			break
+		}
+	}
+	return l.take(rw, key, limit)
+}
+
+func (l *RateLimiter) take(rw http.ResponseWriter, key string, limit RateLimit) error {
+	if limit.Unlimited() {
+		return nil
+	}
+	result, err := l.Store.Take(key, limit)
+	if err != nil {
+		return err
+	}
+	setRateLimitHeaders(rw, result)
+	if !result.Allowed {
+		return RateLimitedError{Key: key, Result: result}
+	}
+	return nil
+}
+
+// setRateLimitHeaders sets the RateLimit-* headers of the IETF draft
+func setRateLimitHeaders(rw http.ResponseWriter, result RateLimitResult) {
+	rw.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	rw.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	rw.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+}
+
+// MemoryRateLimitStore implements RateLimitStore in memory, for a single server
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// NewMemoryRateLimitStore creates an empty MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+// Take takes a token from the bucket of the key, after refilling it for the time
+// since the last call
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.sweep(now)
+
+	burst := float64(limit.Burst)
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, updated: now}
+		s.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*limit.Rate)
+	bucket.updated = now
+
+	result := RateLimitResult{Limit: limit.Burst}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - bucket.tokens) / limit.Rate)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = seconds((burst - bucket.tokens) / limit.Rate)
+	bucket.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep forgets full buckets once a minute, so the store doesn't grow with each
+// client ever seen
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
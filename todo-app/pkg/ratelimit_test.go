@@ -0,0 +1,155 @@
+package todo_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := todo.ParseRateLimit("5:10")
+	require.NoError(t, err)
+	assert.Equal(t, todo.RateLimit{Rate: 5, Burst: 10}, limit)
+
+	limit, err = todo.ParseRateLimit("0.5")
+	require.NoError(t, err)
+	assert.Equal(t, todo.RateLimit{Rate: 0.5, Burst: 1}, limit)
+
+	limit, err = todo.ParseRateLimit("0:0")
+	require.NoError(t, err)
+	assert.True(t, limit.Unlimited())
+
+	for _, invalid := range []string{"", "x", "-1", "1:x", "1:-2", "10:0", "0.5:0"} {
+		_, err = todo.ParseRateLimit(invalid)
+		assert.Error(t, err, invalid)
+		assert.False(t, errors.Is(err, todo.InvalidRequestError), "configuration errors are no request errors")
+	}
+
+	route, limit, err := todo.ParseRouteRateLimit("POST /todo=1:5")
+	require.NoError(t, err)
+	assert.Equal(t, "POST /todo", route)
+	assert.Equal(t, todo.RateLimit{Rate: 1, Burst: 5}, limit)
+	_, _, err = todo.ParseRouteRateLimit("/todo")
+	assert.Error(t, err)
+}
+
+func TestMemoryRateLimitStore_Take(t *testing.T) {
+	store := todo.NewMemoryRateLimitStore()
+	limit := todo.RateLimit{Rate: 1, Burst: 2}
+
+	result, err := store.Take("a", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Limit)
+	assert.Equal(t, 1, result.Remaining)
+
+	result, _ = store.Take("a", limit)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.True(t, result.Reset > time.Second && result.Reset <= 2*time.Second)
+
+	result, _ = store.Take("a", limit)
+	assert.False(t, result.Allowed)
+	assert.True(t, result.RetryAfter > 0 && result.RetryAfter <= time.Second)
+
+	// buckets are separate per key
+	result, _ = store.Take("b", limit)
+	assert.True(t, result.Allowed)
+
+	// buckets refill over time
+	fast := todo.RateLimit{Rate: 1000, Burst: 1}
+	result, _ = store.Take("c", fast)
+	assert.True(t, result.Allowed)
+	result, _ = store.Take("c", fast)
+	assert.False(t, result.Allowed)
+	time.Sleep(5 * time.Millisecond)
+	result, _ = store.Take("c", fast)
+	assert.True(t, result.Allowed)
+}
+
+func TestRouter_ServeHTTP_RateLimit(t *testing.T) {
+	router := testNewRouter()
+	router.RateLimiter = &todo.RateLimiter{
+		Store:  todo.NewMemoryRateLimitStore(),
+		IP:     todo.RateLimit{Rate: 0.001, Burst: 5},
+		User:   todo.RateLimit{Rate: 0.001, Burst: 3},
+		Routes: map[string]todo.RateLimit{"POST /todo": {Rate: 0.001, Burst: 1}},
+	}
+
+	serve := func(method, path, user, pass, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"title":"the-title"}`))
+		req.RemoteAddr = ip + ":1234"
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// per route and method
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/todo", "the-user", "the-pass", "192.0.2.1").Code)
+	rec := serve(http.MethodPost, "/todo", "the-user", "the-pass", "192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// per user, independent of the route limit
+	for i := 0; i < 3; i++ {
+		rec = serve(http.MethodGet, "/todo", "the-user", "the-pass", "192.0.2.2")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "3", rec.Header().Get("RateLimit-Limit"))
+	}
+	rec = serve(http.MethodGet, "/todo", "the-user", "the-pass", "192.0.2.2")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// per IP before authentication, even without valid credentials
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/todo", "the-user", "wrong", "192.0.2.3").Code)
+	}
+	rec = serve(http.MethodGet, "/todo", "the-user", "wrong", "192.0.2.3")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "5", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1000", rec.Header().Get("Retry-After"))
+}
+
+func TestRouter_ServeHTTP_RateLimitPreflights(t *testing.T) {
+	router := testNewRouter()
+	router.RateLimiter = &todo.RateLimiter{
+		Store: todo.NewMemoryRateLimitStore(),
+		IP:    todo.RateLimit{Rate: 0.001, Burst: 2},
+	}
+	router.Middleware = []todo.Middleware{todo.NewCORS("https://app.example.com").Middleware}
+	handler := router.Handler()
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/todo", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// preflights, which CORS answers, share the limit with other requests
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodOptions).Code)
+	rec := serve(http.MethodGet)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(http.MethodOptions).Code)
+
+	// rejected requests still carry the CORS headers
+	rec = serve(http.MethodGet)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+}
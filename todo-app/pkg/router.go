@@ -1,13 +1,62 @@
 package todo
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"mime"
 	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// InvalidRequestError is returned when a request cannot be processed as sent
+var InvalidRequestError = errors.New("invalid request")
+
+// RequestError is an InvalidRequestError with a reason, which is shown to clients
+// so that they can fix their request. Only create it with messages meant for
+// clients, other errors wrapping InvalidRequestError are answered generically.
+type RequestError struct {
+	Reason string
+}
+
+func (e RequestError) Error() string {
+	return e.Reason + ": " + InvalidRequestError.Error()
+}
+
+// Unwrap returns InvalidRequestError
+func (e RequestError) Unwrap() error {
+	return InvalidRequestError
+}
+
+func invalidRequest(format string, args ...interface{}) error {
+	return RequestError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// RequestTooLargeError is returned when a request body exceeds the size limit
+var RequestTooLargeError = errors.New("request body too large")
+
+// UnsupportedMediaTypeError is returned when a request body is not JSON
+var UnsupportedMediaTypeError = errors.New("unsupported media type")
+
+// UnavailableError is returned by Persistence implementations, which can't
+// answer right now, like when a remote server is overloaded
+var UnavailableError = errors.New("service unavailable")
+
+// DefaultMaxBodySize is the size limit of request bodies in bytes, if the Router
+// has none
+const DefaultMaxBodySize = 1 << 20
+
 // Router handles HTTP request routing for the Todo REST API server
 type Router struct {
 	// Prefix is prepended to each route path
@@ -16,66 +65,269 @@ type Router struct {
 	// Authentication validates that requests are from permitted users
 	Authentication Authentication
 
-	// Persistence is used to access Todos
+	// Persistence is used to access Todos. It receives the request context, if
+	// it's a ContextPersistence
 	Persistence Persistence
+
+	// APIKeys is used to manage API keys of users. Key management routes are
+	// only served, if set
+	APIKeys APIKeyStore
+
+	// Users is used to manage users. User management routes are only served,
+	// if set
+	Users UserStore
+
+	// Lockouts tracks failed authentication attempts. Routes for admins to lift
+	// lockouts are only served, if set together with Users
+	Lockouts AttemptTracker
+
+	// Throttle has the limits for guessing passwords in routes, like the old
+	// password when changing it, which are tracked with Lockouts. Defaults to
+	// the limits of NewThrottledAuthentication.
+	Throttle *ThrottledAuthentication
+
+	// Sessions issues session cookies for browser clients. Login and logout
+	// routes are only served, if set
+	Sessions *SessionAuthentication
+
+	// Health answers probes at /healthz, /readyz and /version (without Prefix),
+	// if set
+	Health *Health
+
+	// RateLimiter limits requests per client IP and per user, if set
+	RateLimiter *RateLimiter
+
+	// MaxBodySize limits request bodies in bytes, defaults to DefaultMaxBodySize
+	MaxBodySize int64
+
+	// Middleware wraps all requests, with the first being the outermost, after
+	// panic recovery and before rate limiting and authentication. Preflights,
+	// which Middleware like CORS answers, are rate limited before it.
+	Middleware []Middleware
 }
 
-// ServeHTTP implements the http.Handler interface
+// ServeHTTP implements the http.Handler interface. It builds the routes for each
+// request, use Handler to build them once.
 func (r Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	defer req.Body.Close()
+	r.Handler().ServeHTTP(rw, req)
+}
 
-	// end with an error for all not authenticated requests
-	userId, err := r.Authentication.Authenticate(req)
-	if err != nil {
-		r.handleError(rw, req, err)
-		return
+// Route returns the pattern of the route matching the request path, see
+// RouterHandler.Route. It builds the routes for each call, use Handler to build
+// them once.
+func (r Router) Route(req *http.Request) string {
+	return r.Handler().Route(req)
+}
+
+// RouterHandler serves the routes of a Router, which are built once by Handler
+type RouterHandler struct {
+	http.Handler
+	routes *RouteTable
+	prefix string
+}
+
+// Handler builds the Routes once and returns the handler serving them, wrapped in
+// panic recovery, Middleware and rate limiting per client IP
+func (r Router) Handler() *RouterHandler {
+	routes := r.Routes()
+	middleware := append([]Middleware{Recover, r.limitIP(true)}, r.Middleware...)
+	return &RouterHandler{
+		Handler: Chain(routes, append(middleware, r.limitIP(false))...),
+		routes:  routes,
+		prefix:  r.Prefix,
 	}
-	log.Printf("Request from [%s]: %s %s", userId, req.Method, req.RequestURI)
-
-	// handle
-	// - POST and GET for /todo
-	// - DELETE and GET for a path looking like /todo/<id>
-	path := req.URL.Path
-	todoPath := r.Prefix + "/todo"
-	if path == todoPath {
-		switch req.Method {
-		case http.MethodPost:
-			r.create(rw, req, userId)
-			return
-		case http.MethodGet:
-			r.list(rw, req)
+}
+
+// Route returns the pattern of the route matching the request path, like
+// /todo/{id}, without Prefix. Returns "unmatched" for unknown paths, so that
+// labels in metrics don't grow with each requested path.
+func (h *RouterHandler) Route(req *http.Request) string {
+	return routeName(h.routes.Pattern(req.URL.EscapedPath()), h.prefix)
+}
+
+// Routes returns the route table of the API. Routes of optional features are
+// only added, if they are configured.
+func (r Router) Routes() *RouteTable {
+	routes := NewRouteTable()
+
+	// probes of orchestrators are not authenticated
+	if r.Health != nil {
+		for _, path := range []string{"/healthz", "/readyz", "/version"} {
+			routes.Handle(http.MethodGet, path, r.Health)
+			routes.Handle(http.MethodHead, path, r.Health)
+		}
+	}
+
+	// the contract of the API is public
+	routes.HandleFunc(http.MethodGet, r.Prefix+"/openapi.json", r.openAPI)
+
+	// browser clients login to get a session, before they can be authenticated
+	if r.Sessions != nil {
+		routes.HandleFunc(http.MethodPost, r.Prefix+"/auth/login", r.login)
+		routes.HandleFunc(http.MethodPost, r.Prefix+"/auth/logout", r.logout)
+	}
+
+	routes.Handle(http.MethodPost, r.Prefix+"/todo", r.authenticated(r.create))
+	routes.Handle(http.MethodGet, r.Prefix+"/todo", r.authenticated(func(rw http.ResponseWriter, req *http.Request, _ string) {
+		r.list(rw, req)
+	}))
+	routes.Handle(http.MethodGet, r.Prefix+"/todo/{id}", r.authenticated(func(rw http.ResponseWriter, req *http.Request, _ string) {
+		r.get(rw, req, PathParam(req, "id"))
+	}))
+	routes.Handle(http.MethodDelete, r.Prefix+"/todo/{id}", r.authenticated(func(rw http.ResponseWriter, req *http.Request, _ string) {
+		r.delete(rw, req, PathParam(req, "id"))
+	}))
+
+	if r.APIKeys != nil {
+		routes.Handle(http.MethodPost, r.Prefix+"/apikey", r.loggedIn(r.createAPIKey))
+		routes.Handle(http.MethodGet, r.Prefix+"/apikey", r.loggedIn(r.listAPIKeys))
+		routes.Handle(http.MethodDelete, r.Prefix+"/apikey/{id}", r.loggedIn(func(rw http.ResponseWriter, req *http.Request, userId string) {
+			r.deleteAPIKey(rw, req, userId, PathParam(req, "id"))
+		}))
+	}
+
+	if r.Users != nil {
+		routes.Handle(http.MethodPut, r.Prefix+"/me/password", r.authenticated(r.changePassword))
+		routes.Handle(http.MethodPost, r.Prefix+"/users", r.admin(r.createUser))
+		routes.Handle(http.MethodGet, r.Prefix+"/users", r.admin(r.listUsers))
+		routes.Handle(http.MethodGet, r.Prefix+"/users/{id}", r.admin(func(rw http.ResponseWriter, req *http.Request) {
+			r.getUser(rw, req, PathParam(req, "id"))
+		}))
+		routes.Handle(http.MethodPut, r.Prefix+"/users/{id}", r.admin(func(rw http.ResponseWriter, req *http.Request) {
+			r.updateUser(rw, req, PathParam(req, "id"))
+		}))
+		routes.Handle(http.MethodDelete, r.Prefix+"/users/{id}", r.admin(func(rw http.ResponseWriter, req *http.Request) {
+			r.deleteUser(rw, req, PathParam(req, "id"))
+		}))
+	}
+
+	if r.Users != nil && r.Lockouts != nil {
+		routes.Handle(http.MethodDelete, r.Prefix+"/lockouts/user/{key}", r.admin(func(rw http.ResponseWriter, req *http.Request) {
+			r.unlock(rw, req, LockoutUserKey(PathParam(req, "key")))
+		}))
+		routes.Handle(http.MethodDelete, r.Prefix+"/lockouts/ip/{key}", r.admin(func(rw http.ResponseWriter, req *http.Request) {
+			r.unlock(rw, req, LockoutIPKey(PathParam(req, "key")))
+		}))
+	}
+
+	return routes
+}
+
+// routeName returns the pattern without prefix, see RouterHandler.Route
+func routeName(pattern, prefix string) string {
+	if pattern == "" {
+		return "unmatched"
+	} else if IsHealthPath(pattern) {
+		return pattern
+	}
+	return strings.TrimPrefix(pattern, prefix)
+}
+
+// limitIP rejects clients exceeding the rate limit per IP, before authentication,
+// which is costly. Limits either only CORS preflights, before Middleware answers
+// them, or only other requests.
+func (r Router) limitIP(preflights bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			defer req.Body.Close()
+			if r.RateLimiter != nil && isPreflight(req) == preflights {
+				if err := r.RateLimiter.LimitIP(rw, req); err != nil {
+					r.handleError(rw, req, err)
+					return
+				}
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// authenticated returns a handler, which ends with an error for all not
+// authenticated requests and rejects users exceeding their rate limit
+func (r Router) authenticated(handle func(rw http.ResponseWriter, req *http.Request, userId string)) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		userId, err := r.authenticate(req)
+		if err != nil {
+			r.handleError(rw, req, err)
 			return
 		}
-	} else if strings.HasPrefix(path, todoPath+"/") {
-		id := path[len(todoPath)+1:]
-		switch req.Method {
-		case http.MethodDelete:
-			r.delete(rw, req, id)
+		LoggerFromContext(req.Context()).Debug("authenticated", "user_id", userId)
+		if r.RateLimiter != nil {
+			if err := r.RateLimiter.LimitUser(rw, req, userId, routeName(routePattern(req), r.Prefix)); err != nil {
+				r.handleError(rw, req, err)
+				return
+			}
+		}
+		handle(rw, req, userId)
+	})
+}
+
+// loggedIn returns a handler, which serves authenticated requests without API
+// keys only. Users manage API keys after logging in with a password, session,
+// OIDC token or client certificate, so that a leaked key can't create further
+// keys, which never expire.
+func (r Router) loggedIn(handle func(rw http.ResponseWriter, req *http.Request, userId string)) http.Handler {
+	return r.authenticated(func(rw http.ResponseWriter, req *http.Request, userId string) {
+		if apiKeyFromRequest(req) != "" {
+			r.handleError(rw, req, fmt.Errorf("api keys can't manage api keys: %w", NotAllowedError))
 			return
-		case http.MethodGet:
-			r.get(rw, req, id)
+		}
+		handle(rw, req, userId)
+	})
+}
+
+// admin returns a handler, which serves authenticated admins only
+func (r Router) admin(handle func(rw http.ResponseWriter, req *http.Request)) http.Handler {
+	return r.authenticated(func(rw http.ResponseWriter, req *http.Request, userId string) {
+		if err := r.requireAdmin(userId); err != nil {
+			r.handleError(rw, req, err)
 			return
 		}
-	}
+		handle(rw, req)
+	})
+}
 
-	// anything else, we don't now
-	rw.WriteHeader(http.StatusNotFound)
-	rw.Write([]byte("not found"))
+// todoRequest is the JSON body for creating a Todo. Fields controlled by the
+// server, like ID and creation time, can't be set.
+type todoRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// todoResponse is the JSON representation of a Todo
+type todoResponse struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Created     time.Time `json:"created"`
+	UserID      string    `json:"user_id"`
+}
+
+func newTodoResponse(todo Todo) todoResponse {
+	return todoResponse{
+		ID:          todo.ID,
+		Title:       todo.Title,
+		Description: todo.Description,
+		Created:     todo.Created,
+		UserID:      todo.UserID,
+	}
 }
 
 func (r Router) create(rw http.ResponseWriter, req *http.Request, userId string) {
 
 	// read Todo from JSON body of HTTP request
-	var todo Todo
-	decoder := json.NewDecoder(req.Body)
-	if err := decoder.Decode(&todo); err != nil {
+	var create todoRequest
+	if err := r.decode(req, &create); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	todo := Todo{Title: create.Title, Description: create.Description, UserID: userId}
+	if err := todo.Validate(); err != nil {
 		r.handleError(rw, req, err)
 		return
 	}
 
 	// create Todo in Persistence
-	todo.UserID = userId
-	todoID, err := r.Persistence.Create(todo)
+	todoID, err := r.persistence().CreateContext(req.Context(), todo)
 	if err != nil {
 		r.handleError(rw, req, err)
 		return
@@ -84,16 +336,20 @@ func (r Router) create(rw http.ResponseWriter, req *http.Request, userId string)
 }
 
 func (r Router) list(rw http.ResponseWriter, req *http.Request) {
-	todos, err := r.Persistence.List()
+	todos, err := r.persistence().ListContext(req.Context())
 	if err != nil {
 		r.handleError(rw, req, err)
 		return
 	}
-	r.json(rw, req, todos)
+	out := make([]todoResponse, len(todos))
+	for i, todo := range todos {
+		out[i] = newTodoResponse(todo)
+	}
+	r.json(rw, req, out)
 }
 
 func (r Router) delete(rw http.ResponseWriter, req *http.Request, todoID string) {
-	err := r.Persistence.Delete(todoID)
+	err := r.persistence().DeleteContext(req.Context(), todoID)
 	if err != nil {
 		r.handleError(rw, req, err)
 		return
@@ -102,12 +358,134 @@ func (r Router) delete(rw http.ResponseWriter, req *http.Request, todoID string)
 }
 
 func (r Router) get(rw http.ResponseWriter, req *http.Request, todoID string) {
-	todo, err := r.Persistence.Get(todoID)
+	todo, err := r.persistence().GetContext(req.Context(), todoID)
 	if err != nil {
 		r.handleError(rw, req, err)
 		return
 	}
-	r.json(rw, req, todo)
+	r.json(rw, req, newTodoResponse(*todo))
+}
+
+// authenticate hands over to Authentication, traced as child of the request span
+func (r Router) authenticate(req *http.Request) (string, error) {
+	ctx, span := StartSpan(req.Context(), "authenticate")
+	defer span.Finish()
+	userId, err := r.Authentication.Authenticate(req.WithContext(ctx))
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttribute("user.id", userId)
+	}
+	return userId, err
+}
+
+// persistence returns the Persistence, which logs and traces calls with the
+// Logger and span of the request context
+func (r Router) persistence() ContextPersistence {
+	return LoggingPersistence{Persistence: TracedPersistence{Persistence: AdaptPersistence(r.Persistence)}}
+}
+
+// decode reads the JSON body of the request into data. Bodies must not exceed
+// MaxBodySize, contain unknown fields or anything after the JSON value. Bodies
+// without content type are assumed to be JSON.
+func (r Router) decode(req *http.Request, data interface{}) error {
+	if contentType := req.Header.Get("content-type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			return fmt.Errorf("content type %q is not JSON: %w", contentType, UnsupportedMediaTypeError)
+		}
+	}
+
+	maxSize := r.MaxBodySize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxSize+1))
+	if err != nil {
+		return err
+	} else if int64(len(body)) > maxSize {
+		return fmt.Errorf("body exceeds %d bytes: %w", maxSize, RequestTooLargeError)
+	} else if len(bytes.TrimSpace(body)) == 0 {
+		return invalidRequest("empty body")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	if err = decoder.Decode(data); errors.As(err, &syntaxError) {
+		return invalidRequest("malformed JSON at offset %d", syntaxError.Offset)
+	} else if errors.As(err, &typeError) {
+		return invalidRequest("field %q must be of type %s", typeError.Field, typeError.Type)
+	} else if errors.Is(err, io.ErrUnexpectedEOF) {
+		return invalidRequest("incomplete JSON")
+	} else if field := unknownField(body, data); err != nil && field != "" {
+		return invalidRequest("unknown field %q", field)
+	} else if err != nil {
+		return invalidRequest("invalid JSON")
+	} else if _, err = decoder.Token(); err != io.EOF {
+		return invalidRequest("unexpected data after JSON at offset %d", decoder.InputOffset())
+	}
+	return nil
+}
+
+// unknownField returns the first field of the JSON object body, which the struct
+// data points to has no field for, or an empty string
+func unknownField(body []byte, data interface{}) string {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return ""
+	}
+	typ := reflect.TypeOf(data)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return ""
+	}
+
+	known := jsonFieldNames(typ)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !known[strings.ToLower(name)] {
+			return name
+		}
+	}
+	return ""
+}
+
+// jsonFieldNames returns the lower case JSON names of the struct fields, which
+// encoding/json matches case-insensitively, including those of embedded structs
+func jsonFieldNames(typ reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		embedded := field.Type
+		if embedded.Kind() == reflect.Ptr {
+			embedded = embedded.Elem()
+		}
+		if field.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+			for embeddedName := range jsonFieldNames(embedded) {
+				names[embeddedName] = true
+			}
+			continue
+		} else if !field.IsExported() {
+			continue
+		} else if name == "" {
+			name = field.Name
+		}
+		names[strings.ToLower(name)] = true
+	}
+	return names
 }
 
 // json prints out a JSON HTTP response
@@ -122,13 +500,58 @@ func (r Router) json(rw http.ResponseWriter, req *http.Request, data interface{}
 
 // handleError prints out errors in the logs and lets the request fail
 func (r Router) handleError(rw http.ResponseWriter, req *http.Request, err error) {
-	log.Printf("Error in %s %s: %s", req.Method, req.URL, err)
-	rw.Header().Set("content-type", "application/json")
-	if errors.Is(NotAllowedError, err) {
-		rw.WriteHeader(http.StatusForbidden)
-		rw.Write([]byte(`{"error":"forbidden"}`))
-	} else {
-		rw.WriteHeader(http.StatusInternalServerError)
-		rw.Write([]byte(`{"error":"internal server error"}`))
+	status, message := http.StatusInternalServerError, "internal server error"
+	var tooManyAttempts TooManyAttemptsError
+	var rateLimited RateLimitedError
+	if errors.As(err, &rateLimited) {
+		retryAfter := int(math.Ceil(rateLimited.Result.RetryAfter.Seconds()))
+		rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		status, message = http.StatusTooManyRequests, "too many requests"
+	} else if errors.As(err, &tooManyAttempts) {
+		retryAfter := int(math.Ceil(tooManyAttempts.RetryAfter.Seconds()))
+		rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		status, message = http.StatusTooManyRequests, "too many requests"
+	} else if errors.Is(err, MissingCredentialsError) {
+		if challenger, ok := r.Authentication.(Challenger); ok {
+			for _, challenge := range challenger.Challenges() {
+				rw.Header().Add("WWW-Authenticate", challenge)
+			}
+		}
+		status, message = http.StatusUnauthorized, "unauthorized"
+	} else if errors.Is(err, NotAllowedError) {
+		status, message = http.StatusForbidden, "forbidden"
+	} else if errors.Is(err, RequestTooLargeError) {
+		status, message = http.StatusRequestEntityTooLarge, "request body too large"
+	} else if errors.Is(err, UnsupportedMediaTypeError) {
+		status, message = http.StatusUnsupportedMediaType, "unsupported media type, expected application/json"
+	} else if errors.Is(err, InvalidRequestError) {
+		// the reasons of invalid requests help clients to fix them
+		status, message = http.StatusBadRequest, "invalid request"
+		var requestError RequestError
+		if errors.As(err, &requestError) {
+			status, message = http.StatusBadRequest, requestError.Reason
+		}
+	} else if errors.Is(err, DuplicateUserError) {
+		status, message = http.StatusConflict, "conflict"
+	} else if errors.Is(err, LastAdminError) {
+		status, message = http.StatusConflict, "the last admin can't be deleted or demoted"
+	} else if errors.Is(err, os.ErrNotExist) {
+		status, message = http.StatusNotFound, "not found"
+	} else if errors.Is(err, UnavailableError) {
+		status, message = http.StatusServiceUnavailable, "service unavailable"
+	} else if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		status, message = http.StatusServiceUnavailable, "request canceled"
 	}
+
+	// failures of the server need attention, rejected or canceled requests not
+	logger := LoggerFromContext(req.Context())
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError && status != http.StatusServiceUnavailable {
+		level = slog.LevelError
+	}
+	logger.Log(req.Context(), level, "request failed", "method", req.Method, "url", RedactURL(req.URL), "status", status, "error", err)
+
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(map[string]string{"error": message})
 }
@@ -0,0 +1,76 @@
+package todo
+
+import (
+	"net/http"
+	"os"
+	"time"
+)
+
+// apiKeyCreateRequest is the JSON body for creating a new API key
+type apiKeyCreateRequest struct {
+	Name    string        `json:"name"`
+	Scopes  []APIKeyScope `json:"scopes"`
+	Expires *time.Time    `json:"expires"`
+}
+
+// apiKeyCreateResponse contains the secret of the key, which is only returned once
+type apiKeyCreateResponse struct {
+	APIKey
+	Secret string `json:"secret"`
+}
+
+func (r Router) createAPIKey(rw http.ResponseWriter, req *http.Request, userId string) {
+
+	// read key parameters from JSON body of HTTP request
+	var create apiKeyCreateRequest
+	if err := r.decode(req, &create); err != nil {
+		r.handleError(rw, req, err)
+		return
+	} else if create.Expires != nil && create.Expires.Before(time.Now()) {
+		r.handleError(rw, req, invalidRequest("expiry in the past"))
+		return
+	}
+
+	key, secret, err := NewAPIKey(userId, create.Name, create.Scopes, create.Expires)
+	if err != nil {
+		r.handleError(rw, req, err)
+		return
+	} else if err = r.APIKeys.Create(key); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+
+	key.Hash = ""
+	r.json(rw, req, apiKeyCreateResponse{APIKey: key, Secret: secret})
+}
+
+func (r Router) listAPIKeys(rw http.ResponseWriter, req *http.Request, userId string) {
+	keys, err := r.APIKeys.List(userId)
+	if err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	for i := range keys {
+		keys[i].Hash = ""
+	}
+	r.json(rw, req, keys)
+}
+
+func (r Router) deleteAPIKey(rw http.ResponseWriter, req *http.Request, userId, keyID string) {
+
+	// keys of other users are treated as not existing
+	key, err := r.APIKeys.Get(keyID)
+	if err != nil {
+		r.handleError(rw, req, err)
+		return
+	} else if key.UserID != userId {
+		r.handleError(rw, req, os.ErrNotExist)
+		return
+	}
+
+	if err = r.APIKeys.Delete(keyID); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	r.json(rw, req, map[string]string{"id": keyID})
+}
@@ -0,0 +1,56 @@
+package todo
+
+import (
+	"net/http"
+)
+
+// loginRequest is the JSON body for logging in
+type loginRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// login checks credentials with the Authentication of the Router, as if they were
+// sent with HTTP basic auth, and starts a session
+func (r Router) login(rw http.ResponseWriter, req *http.Request) {
+	var login loginRequest
+	if err := r.decode(req, &login); err != nil {
+		r.handleError(rw, req, err)
+		return
+	} else if login.Name == "" || login.Password == "" {
+		r.handleError(rw, req, invalidRequest("name and password required"))
+		return
+	}
+
+	// only the given credentials count, no cookies or other headers
+	check := req.Clone(req.Context())
+	check.Header = make(http.Header)
+	check.SetBasicAuth(login.Name, login.Password)
+	userId, err := r.authenticate(check)
+	if err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+
+	session, err := r.Sessions.Login(rw, req, userId)
+	if err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	r.json(rw, req, map[string]string{"user_id": userId, "csrf_token": session.CSRFToken})
+}
+
+func (r Router) logout(rw http.ResponseWriter, req *http.Request) {
+
+	// logging out is state changing, which requires the CSRF token
+	if _, err := r.Sessions.Authenticate(req); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+
+	if err := r.Sessions.Logout(rw, req); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	r.json(rw, req, map[string]string{})
+}
@@ -2,15 +2,19 @@ package todo_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -49,6 +53,103 @@ func TestRouter_ServeHTTP_Create(t *testing.T) {
 	assert.NotEmpty(t, out["id"])
 }
 
+func TestRouter_ServeHTTP_CreateInvalid(t *testing.T) {
+	router := testNewRouter()
+	router.MaxBodySize = 64
+	for _, c := range []struct {
+		body, contentType string
+		status            int
+		message           string
+	}{
+		{`{"titel":"typo"}`, "", http.StatusBadRequest, `unknown field "titel"`},
+		{`{"Title":"the-title","Titel":"typo"}`, "", http.StatusBadRequest, `unknown field "Titel"`},
+		{`{"title":"the-title"} {"title":"more"}`, "", http.StatusBadRequest, "unexpected data after JSON"},
+		{`{"title":"the-title"`, "", http.StatusBadRequest, "incomplete JSON"},
+		{`{"title":1}`, "", http.StatusBadRequest, `field "title" must be of type string`},
+		{`{"title":"  "}`, "", http.StatusBadRequest, "title required"},
+		{``, "", http.StatusBadRequest, "empty body"},
+		{`{"title":"` + strings.Repeat("a", todo.MaxTitleLength+1) + `"}`, "", http.StatusRequestEntityTooLarge, "request body too large"},
+		{`{"title":"the-title"}`, "text/plain", http.StatusUnsupportedMediaType, "unsupported media type"},
+		{`{"title":"the-title"}`, "application/json; charset=utf-8", http.StatusOK, ""},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/todo", strings.NewReader(c.body))
+		req.SetBasicAuth("the-user", "the-pass")
+		if c.contentType != "" {
+			req.Header.Set("content-type", c.contentType)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, c.status, rec.Code, c.body)
+		out := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+		if c.message != "" {
+			assert.Contains(t, out["error"], c.message, c.body)
+		}
+	}
+
+	// titles are limited in characters, not bytes
+	router.MaxBodySize = 0
+	assert.NoError(t, todo.Todo{Title: strings.Repeat("ä", todo.MaxTitleLength)}.Validate())
+	assert.Error(t, todo.Todo{Title: strings.Repeat("ä", todo.MaxTitleLength+1)}.Validate())
+	req := httptest.NewRequest(http.MethodPost, "/todo", strings.NewReader(`{"title":"`+strings.Repeat("a", todo.MaxTitleLength+1)+`"}`))
+	req.SetBasicAuth("the-user", "the-pass")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "title exceeds")
+}
+
+func TestRouter_ServeHTTP_CreateServerControlledFields(t *testing.T) {
+	existing := assertJSONTodoFile(t, 2)
+	defer os.Remove(existing)
+	router := testNewRouter()
+	router.Persistence = createTestDirectoryPersistence(t)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.SetBasicAuth("the-user", "the-pass")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// clients can't choose IDs, creation times or users
+	for _, body := range []string{
+		`{"id":"todo-02","title":"overwrite"}`,
+		`{"id":"../../escaped","title":"traverse"}`,
+		`{"title":"old","created":"2000-01-01T00:00:00Z"}`,
+		`{"title":"other user","user_id":"u02"}`,
+	} {
+		rec := serve(http.MethodPost, "/todo", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+	rec := serve(http.MethodGet, "/todo/todo-02", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"title":"todo 02"`)
+
+	// IDs escaping the directory are not found
+	for _, path := range []string{"/todo/..%2Ftodo-02", "/todo/%2E%2E", "/todo/.hidden"} {
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, path, "").Code, path)
+		assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, path, "").Code, path)
+	}
+
+	// the server assigns ID, creation time and user
+	before := time.Now().Add(-time.Second)
+	rec = serve(http.MethodPost, "/todo", `{"title":"the-title"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	created := make(map[string]string)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	defer os.Remove(filepath.Join(testPersistenceDir, created["id"]+".json"))
+
+	rec = serve(http.MethodGet, "/todo/"+created["id"], "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var td todo.Todo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &td))
+	assert.Equal(t, created["id"], td.ID)
+	assert.Equal(t, "the-user", td.UserID)
+	assert.True(t, td.Created.After(before))
+}
+
 func TestRouter_ServeHTTP_List(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
 	req.SetBasicAuth("the-user", "the-pass")
@@ -116,6 +217,86 @@ func TestRouter_ServeHTTP_Get(t *testing.T) {
 	}, out)
 }
 
+func TestRouter_ServeHTTP_Context(t *testing.T) {
+	store := &testContextPersistence{testPersistence: testPersistence{}}
+	router := testNewRouter()
+	router.Persistence = store
+
+	// the request context reaches the persistence
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	req.SetBasicAuth("the-user", "the-pass")
+	req = req.WithContext(todo.ContextWithRequestID(req.Context(), "req-1"))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, store.ctx)
+	assert.Equal(t, "req-1", todo.RequestIDFromContext(store.ctx))
+
+	// canceled requests end early
+	router.Persistence = createTestDirectoryPersistence(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req.WithContext(ctx))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestRouter_ServeHTTP_InvalidRequestReasons(t *testing.T) {
+	router := testNewRouter()
+	router.Persistence = testFailingPersistence{
+		testPersistence: testPersistence{},
+		err:             fmt.Errorf("remote: 400 from http://10.0.0.1/v1/todo: %w", todo.InvalidRequestError),
+	}
+
+	// only reasons meant for clients are shown to them
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	req.SetBasicAuth("the-user", "the-pass")
+	rec := httptest.NewRecorder()
+	router.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":"invalid request"}`, rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/todo", strings.NewReader(`{"title":" "}`))
+	req.SetBasicAuth("the-user", "the-pass")
+	rec = httptest.NewRecorder()
+	router.Handler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":"title required"}`, rec.Body.String())
+}
+
+// testFailingPersistence fails to list with the error
+type testFailingPersistence struct {
+	testPersistence
+	err error
+}
+
+func (p testFailingPersistence) List() ([]todo.Todo, error) {
+	return nil, p.err
+}
+
+// testContextPersistence records the context of the last ListContext call
+type testContextPersistence struct {
+	testPersistence
+	ctx context.Context
+}
+
+func (p *testContextPersistence) CreateContext(ctx context.Context, td todo.Todo) (string, error) {
+	return p.Create(td)
+}
+
+func (p *testContextPersistence) DeleteContext(ctx context.Context, id string) error {
+	return p.Delete(id)
+}
+
+func (p *testContextPersistence) GetContext(ctx context.Context, id string) (*todo.Todo, error) {
+	return p.Get(id)
+}
+
+func (p *testContextPersistence) ListContext(ctx context.Context) ([]todo.Todo, error) {
+	p.ctx = ctx
+	return p.List()
+}
+
 func TestRouter_ServeHTTP_Fallback(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.SetBasicAuth("the-user", "the-pass")
@@ -196,10 +377,337 @@ type testAuthentication map[string]string
 func (a testAuthentication) Authenticate(req *http.Request) (userID string, err error) {
 	user, pass, hasBasic := req.BasicAuth()
 	if !hasBasic {
-		return "", errors.New("missing basic credentials")
+		return "", todo.MissingCredentialsError
 	}
 	if known, has := a[user]; has && pass == known {
 		return user, nil
 	}
-	return "", todo.NotAllowedError
+	return "", todo.InvalidCredentialsError
+}
+
+func TestRouter_ServeHTTP_APIKeys(t *testing.T) {
+	store, err := todo.NewFileAPIKeyStore("")
+	require.NoError(t, err)
+	router := testNewRouter()
+	router.APIKeys = store
+
+	// create returns the secret once
+	req := httptest.NewRequest(http.MethodPost, "/apikey", bytes.NewBuffer([]byte(`{"name":"ci", "scopes":["write"]}`)))
+	req.SetBasicAuth("the-user", "the-pass")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	created := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.NotEmpty(t, created["secret"])
+	assert.NotContains(t, created, "hash")
+	id := created["id"].(string)
+
+	// list does not contain the secret
+	req = httptest.NewRequest(http.MethodGet, "/apikey", nil)
+	req.SetBasicAuth("the-user", "the-pass")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	listed := make([]map[string]interface{}, 0)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, id, listed[0]["id"])
+	assert.NotContains(t, listed[0], "secret")
+	assert.NotContains(t, listed[0], "hash")
+
+	// unknown scopes are rejected
+	req = httptest.NewRequest(http.MethodPost, "/apikey", bytes.NewBuffer([]byte(`{"scopes":["admin"]}`)))
+	req.SetBasicAuth("the-user", "the-pass")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// revoke
+	req = httptest.NewRequest(http.MethodDelete, "/apikey/"+id, nil)
+	req.SetBasicAuth("the-user", "the-pass")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/apikey/"+id, nil)
+	req.SetBasicAuth("the-user", "the-pass")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRouter_ServeHTTP_APIKeysNotManagedWithAPIKeys(t *testing.T) {
+	store, err := todo.NewFileAPIKeyStore("")
+	require.NoError(t, err)
+	router := testNewRouter()
+	router.APIKeys = store
+	router.Authentication = todo.ChainAuthentication{
+		testAuthentication{"the-user": "the-pass"},
+		todo.APIKeyAuthentication{Store: store},
+	}
+	key, secret, err := todo.NewAPIKey("the-user", "ci", []todo.APIKeyScope{todo.APIKeyScopeWrite}, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Create(key))
+
+	for _, expect := range []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/apikey", `{"name":"forever","scopes":["write"]}`},
+		{http.MethodGet, "/apikey", ""},
+		{http.MethodDelete, "/apikey/" + key.ID, ""},
+	} {
+		for _, header := range []string{"X-API-Key", "Authorization"} {
+			req := httptest.NewRequest(expect.method, expect.path, bytes.NewBuffer([]byte(expect.body)))
+			if header == "Authorization" {
+				req.Header.Set(header, "Bearer "+secret)
+			} else {
+				req.Header.Set(header, secret)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s with %s", expect.method, expect.path, header)
+		}
+	}
+
+	// the key itself still works for todos
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	req.Header.Set("X-API-Key", secret)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	keys, err := store.List("the-user")
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
+
+func TestRouter_ServeHTTP_ChallengeMissingCredentials(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/todo", nil)
+
+	router := testNewRouter()
+	router.Authentication = todo.ChainAuthentication{
+		todo.UsersAuthentication{},
+		todo.APIKeyAuthentication{},
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, []string{`Basic realm="todo"`, `Bearer realm="todo"`}, res.Header.Values("WWW-Authenticate"))
+}
+
+func TestRouter_ServeHTTP_Users(t *testing.T) {
+	router := testNewRouter()
+	router.Authentication = testAuthentication{"admin": "the-pass", "the-user": "the-pass"}
+	router.Users = &testUserStore{
+		{ID: "admin", Name: "admin", Password: "the-pass", Admin: true},
+		{ID: "the-user", Name: "the-user", Password: "the-pass"},
+	}
+
+	serve := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBuffer([]byte(body)))
+		req.SetBasicAuth(user, "the-pass")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// non admins are not permitted
+	assert.Equal(t, http.StatusForbidden, serve("the-user", http.MethodGet, "/users", "").Code)
+
+	// admins manage users
+	rec := serve("admin", http.MethodPost, "/users", `{"name":"carol","password":"secret3"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	created := make(map[string]string)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	id := created["id"]
+
+	assert.Equal(t, http.StatusConflict, serve("admin", http.MethodPost, "/users", `{"name":"carol","password":"x"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve("admin", http.MethodPost, "/users", `{"name":"dave"}`).Code)
+
+	rec = serve("admin", http.MethodPut, "/users/"+id, `{"admin":true}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":"`+id+`","name":"carol","admin":true}`, rec.Body.String())
+
+	rec = serve("admin", http.MethodGet, "/users", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "secret")
+
+	assert.Equal(t, http.StatusOK, serve("admin", http.MethodDelete, "/users/"+id, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve("admin", http.MethodGet, "/users/"+id, "").Code)
+
+	// users change their own password
+	assert.Equal(t, http.StatusForbidden, serve("the-user", http.MethodPut, "/me/password", `{"old_password":"wrong","new_password":"new"}`).Code)
+	assert.Equal(t, http.StatusOK, serve("the-user", http.MethodPut, "/me/password", `{"old_password":"the-pass","new_password":"new"}`).Code)
+	user, err := router.Users.Get("the-user")
+	require.NoError(t, err)
+	assert.True(t, user.PasswordHashed())
+	assert.True(t, user.CheckPassword("new"))
+
+	// passwords of created users are hashed
+	rec = serve("admin", http.MethodPost, "/users", `{"name":"dave","password":"secret4"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	user, err = router.Users.Get(created["id"])
+	require.NoError(t, err)
+	assert.NotContains(t, user.Password, "secret4")
+	assert.True(t, user.CheckPassword("secret4"))
+
+	// someone must remain to manage users
+	assert.Equal(t, http.StatusConflict, serve("admin", http.MethodDelete, "/users/admin", "").Code)
+	assert.Equal(t, http.StatusConflict, serve("admin", http.MethodPut, "/users/admin", `{"admin":false}`).Code)
+	assert.Equal(t, http.StatusOK, serve("admin", http.MethodPut, "/users/"+created["id"], `{"admin":true}`).Code)
+	assert.Equal(t, http.StatusOK, serve("admin", http.MethodPut, "/users/admin", `{"admin":false}`).Code)
+}
+
+func TestRouter_ServeHTTP_ChangePassword_Throttled(t *testing.T) {
+	throttle := todo.NewThrottledAuthentication(nil, nil)
+	throttle.Backoff = time.Minute
+	router := testNewRouter()
+	router.Authentication = testAuthentication{"the-user": "the-pass"}
+	router.Users = &testUserStore{{ID: "the-user", Name: "the-user", Password: "the-pass"}}
+	router.Lockouts = todo.NewMemoryAttemptTracker(time.Hour)
+	router.Throttle = &throttle
+
+	serve := func(oldPassword string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/me/password", bytes.NewBufferString(`{"old_password":"`+oldPassword+`","new_password":"new"}`))
+		req.SetBasicAuth("the-user", "the-pass")
+		rec := httptest.NewRecorder()
+		router.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// a wrong guess makes the next one wait, even if it is right
+	assert.Equal(t, http.StatusForbidden, serve("wrong").Code)
+	rec := serve("wrong")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("the-pass").Code)
+
+	count, _, err := router.Lockouts.Failures(todo.LockoutUserKey("the-user"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// once lifted, the right password is accepted
+	require.NoError(t, router.Lockouts.Reset(todo.LockoutUserKey("the-user")))
+	require.NoError(t, router.Lockouts.Reset(todo.LockoutIPKey("192.0.2.1")))
+	assert.Equal(t, http.StatusOK, serve("the-pass").Code)
+}
+
+type testUserStore []todo.User
+
+func (s *testUserStore) Create(user todo.User) (string, error) {
+	for _, existing := range *s {
+		if existing.Name == user.Name {
+			return "", todo.DuplicateUserError
+		}
+	}
+	if user.ID == "" {
+		user.ID = testID()
+	}
+	*s = append(*s, user)
+	return user.ID, nil
+}
+
+func (s *testUserStore) Delete(id string) error {
+	for i, user := range *s {
+		if user.ID == id {
+			*s = append((*s)[:i], (*s)[i+1:]...)
+			return nil
+		}
+	}
+	return os.ErrNotExist
+}
+
+func (s *testUserStore) Get(id string) (*todo.User, error) {
+	for _, user := range *s {
+		if user.ID == id {
+			return &user, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (s *testUserStore) List() ([]todo.User, error) {
+	return append([]todo.User{}, *s...), nil
+}
+
+func (s *testUserStore) Update(user todo.User) error {
+	for i, existing := range *s {
+		if existing.ID == user.ID {
+			(*s)[i] = user
+			return nil
+		}
+	}
+	return os.ErrNotExist
+}
+
+func TestRouter_ServeHTTP_Lockout(t *testing.T) {
+	tracker := todo.NewMemoryAttemptTracker(time.Hour)
+	auth := todo.NewThrottledAuthentication(testAuthentication{"admin": "the-pass", "the-user": "the-pass"}, tracker)
+	auth.MaxUserFailures = 1
+	auth.Backoff = 0
+
+	router := testNewRouter()
+	router.Authentication = auth
+	router.Lockouts = tracker
+	router.Users = &testUserStore{{ID: "admin", Name: "admin", Password: "the-pass", Admin: true}}
+
+	serve := func(user, pass, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.SetBasicAuth(user, pass)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusForbidden, serve("the-user", "invalid", http.MethodGet, "/todo").Code)
+	rec := serve("the-user", "the-pass", http.MethodGet, "/todo")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "900", rec.Header().Get("Retry-After"))
+
+	// admin lifts the lockout
+	require.Equal(t, http.StatusOK, serve("admin", "the-pass", http.MethodDelete, "/lockouts/user/the-user").Code)
+	assert.Equal(t, http.StatusOK, serve("the-user", "the-pass", http.MethodGet, "/todo").Code)
+}
+
+func TestRouter_ServeHTTP_Sessions(t *testing.T) {
+	sessions := todo.NewSessionAuthentication(todo.NewMemorySessionStore())
+	router := testNewRouter()
+	router.Sessions = sessions
+	router.Authentication = todo.ChainAuthentication{
+		testAuthentication{"the-user": "the-pass"},
+		sessions,
+	}
+
+	serve := func(method, path, body string, cookies []*http.Cookie, csrf string) *httptest.ResponseRecorder {
+		req := createSessionTestRequest(method, cookies, csrf)
+		req.URL.Path = path
+		req.Body = ioutil.NopCloser(bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/auth/login", `{"name":"the-user","password":"wrong"}`, nil, "").Code)
+
+	rec := serve(http.MethodPost, "/auth/login", `{"name":"the-user","password":"the-pass"}`, nil, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	cookies := rec.Result().Cookies()
+	out := make(map[string]string)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &out))
+	csrf := out["csrf_token"]
+	assert.Equal(t, cookieValue(cookies, todo.CSRFCookieName), csrf)
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/todo", "", cookies, "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/todo", `{"title":"x"}`, cookies, "").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/todo", `{"title":"x"}`, cookies, csrf).Code)
+
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/auth/logout", "", cookies, "").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/auth/logout", "", cookies, csrf).Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/todo", "", cookies, "").Code)
 }
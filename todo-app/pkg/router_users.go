@@ -0,0 +1,208 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// userResponse is a User without it's password
+type userResponse struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
+}
+
+// userRequest is the JSON body for creating or updating a user. Omitted fields
+// are not changed on update.
+type userRequest struct {
+	Name     *string `json:"name"`
+	Password *string `json:"password"`
+	Admin    *bool   `json:"admin"`
+}
+
+// passwordRequest is the JSON body for users changing their own password
+type passwordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func newUserResponse(user User) userResponse {
+	return userResponse{ID: user.ID, Name: user.Name, Admin: user.Admin}
+}
+
+// requireAdmin returns NotAllowedError unless the user is an administrator
+func (r Router) requireAdmin(userId string) error {
+	user, err := r.Users.Get(userId)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unknown user %s: %w", userId, NotAllowedError)
+	} else if err != nil {
+		return err
+	} else if !user.Admin {
+		return fmt.Errorf("user %s is not an admin: %w", userId, NotAllowedError)
+	}
+	return nil
+}
+
+func (r Router) listUsers(rw http.ResponseWriter, req *http.Request) {
+	users, err := r.Users.List()
+	if err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	out := make([]userResponse, len(users))
+	for i, user := range users {
+		out[i] = newUserResponse(user)
+	}
+	r.json(rw, req, out)
+}
+
+func (r Router) createUser(rw http.ResponseWriter, req *http.Request) {
+	var create userRequest
+	if err := r.decode(req, &create); err != nil {
+		r.handleError(rw, req, err)
+		return
+	} else if create.Name == nil || *create.Name == "" || create.Password == nil || *create.Password == "" {
+		r.handleError(rw, req, invalidRequest("name and password required"))
+		return
+	}
+
+	user := User{Name: *create.Name}
+	if create.Admin != nil {
+		user.Admin = *create.Admin
+	}
+	if err := user.SetPassword(*create.Password); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	userID, err := r.Users.Create(user)
+	if err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	r.json(rw, req, map[string]string{"id": userID})
+}
+
+func (r Router) getUser(rw http.ResponseWriter, req *http.Request, userID string) {
+	user, err := r.Users.Get(userID)
+	if err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	r.json(rw, req, newUserResponse(*user))
+}
+
+func (r Router) updateUser(rw http.ResponseWriter, req *http.Request, userID string) {
+	var update userRequest
+	if err := r.decode(req, &update); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+
+	user, err := r.Users.Get(userID)
+	if err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	if update.Name != nil {
+		user.Name = *update.Name
+	}
+	if update.Password != nil {
+		if *update.Password == "" {
+			r.handleError(rw, req, invalidRequest("empty password"))
+			return
+		}
+		if err = user.SetPassword(*update.Password); err != nil {
+			r.handleError(rw, req, err)
+			return
+		}
+	}
+	if update.Admin != nil {
+		if user.Admin && !*update.Admin {
+			if err = CheckLastAdmin(r.Users, user.ID); err != nil {
+				r.handleError(rw, req, err)
+				return
+			}
+		}
+		user.Admin = *update.Admin
+	}
+
+	if err = r.Users.Update(*user); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	r.json(rw, req, newUserResponse(*user))
+}
+
+func (r Router) deleteUser(rw http.ResponseWriter, req *http.Request, userID string) {
+	if err := CheckLastAdmin(r.Users, userID); err != nil {
+		r.handleError(rw, req, err)
+		return
+	} else if err = r.Users.Delete(userID); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	r.json(rw, req, map[string]string{"id": userID})
+}
+
+func (r Router) changePassword(rw http.ResponseWriter, req *http.Request, userId string) {
+	var change passwordRequest
+	if err := r.decode(req, &change); err != nil {
+		r.handleError(rw, req, err)
+		return
+	} else if change.NewPassword == "" {
+		r.handleError(rw, req, invalidRequest("empty password"))
+		return
+	}
+
+	user, err := r.Users.Get(userId)
+	if errors.Is(err, os.ErrNotExist) {
+		r.handleError(rw, req, fmt.Errorf("unknown user %s: %w", userId, NotAllowedError))
+		return
+	} else if err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+
+	// a stolen session must not allow guessing the password
+	err = r.passwordThrottle().Attempt(req, user.Name, func() error {
+		if !user.CheckPassword(change.OldPassword) {
+			return fmt.Errorf("wrong old password: %w", InvalidCredentialsError)
+		}
+		return nil
+	})
+	if err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+
+	if err = user.SetPassword(change.NewPassword); err != nil {
+		r.handleError(rw, req, err)
+		return
+	} else if err = r.Users.Update(*user); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	r.json(rw, req, map[string]string{"id": user.ID})
+}
+
+// passwordThrottle returns the Throttle, or the default limits, which tracks
+// checks of passwords with the Lockouts
+func (r Router) passwordThrottle() ThrottledAuthentication {
+	throttled := NewThrottledAuthentication(nil, nil)
+	if r.Throttle != nil {
+		throttled = *r.Throttle
+	}
+	throttled.Tracker = r.Lockouts
+	return throttled
+}
+
+// unlock lifts the lockout of a key of a user name or a client IP
+func (r Router) unlock(rw http.ResponseWriter, req *http.Request, lockoutKey string) {
+	if err := r.Lockouts.Reset(lockoutKey); err != nil {
+		r.handleError(rw, req, err)
+		return
+	}
+	r.json(rw, req, map[string]string{"unlocked": lockoutKey})
+}
@@ -0,0 +1,158 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// RouteTable dispatches requests to handlers by method and path pattern. Patterns
+// like /todo/{id} match a single path segment for each parameter, which handlers
+// read with PathParam. Unknown paths get a 404, known paths with other methods a
+// 405 with the Allow header.
+type RouteTable struct {
+	routes []*route
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	static   int
+	handlers map[string]http.Handler
+}
+
+// NewRouteTable creates an empty RouteTable
+func NewRouteTable() *RouteTable {
+	return &RouteTable{}
+}
+
+// Handle adds the handler for the method and pattern
+func (t *RouteTable) Handle(method, pattern string, handler http.Handler) {
+	for _, existing := range t.routes {
+		if existing.pattern == pattern {
+			existing.handlers[method] = handler
+			return
+		}
+	}
+
+	r := &route{
+		pattern:  pattern,
+		segments: strings.Split(strings.TrimPrefix(pattern, "/"), "/"),
+		handlers: map[string]http.Handler{method: handler},
+	}
+	for _, segment := range r.segments {
+		if !isPathParam(segment) {
+			r.static++
+		}
+	}
+	t.routes = append(t.routes, r)
+}
+
+// HandleFunc adds the handler function for the method and pattern
+func (t *RouteTable) HandleFunc(method, pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	t.Handle(method, pattern, http.HandlerFunc(handler))
+}
+
+// Pattern returns the pattern matching the path, or an empty string
+func (t *RouteTable) Pattern(path string) string {
+	if r, _ := t.match(path); r != nil {
+		return r.pattern
+	}
+	return ""
+}
+
+// Patterns returns the patterns of all routes, with their sorted methods
+func (t *RouteTable) Patterns() map[string][]string {
+	patterns := make(map[string][]string, len(t.routes))
+	for _, r := range t.routes {
+		patterns[r.pattern] = r.methods()
+	}
+	return patterns
+}
+
+// ServeHTTP implements the http.Handler interface
+func (t *RouteTable) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r, params := t.match(req.URL.EscapedPath())
+	if r == nil {
+		writeJSONError(rw, http.StatusNotFound, "not found")
+		return
+	}
+
+	handler, ok := r.handlers[req.Method]
+	if !ok {
+		rw.Header().Set("Allow", strings.Join(r.methods(), ", "))
+		writeJSONError(rw, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx := context.WithValue(req.Context(), pathParamsContextKey, params)
+	ctx = context.WithValue(ctx, routePatternContextKey, r.pattern)
+	handler.ServeHTTP(rw, req.WithContext(ctx))
+}
+
+// match returns the route matching the escaped path with the most static
+// segments, and the unescaped path parameters
+func (t *RouteTable) match(path string) (*route, map[string]string) {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	var best *route
+	var bestParams map[string]string
+
+routes:
+	for _, r := range t.routes {
+		if len(r.segments) != len(segments) || best != nil && r.static <= best.static {
+			continue
+		}
+		params := make(map[string]string)
+		for i, segment := range r.segments {
+			if !isPathParam(segment) {
+				if segment != segments[i] {
+					continue routes
+				}
+				continue
+			}
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				continue routes
+			}
+			params[segment[1:len(segment)-1]] = value
+		}
+		best, bestParams = r, params
+	}
+
+	return best, bestParams
+}
+
+func (r *route) methods() []string {
+	methods := make([]string, 0, len(r.handlers))
+	for method := range r.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+func isPathParam(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
+
+// PathParam returns the value of the path parameter of the route matching the
+// request, like id for /todo/{id}
+func PathParam(req *http.Request, name string) string {
+	params, _ := req.Context().Value(pathParamsContextKey).(map[string]string)
+	return params[name]
+}
+
+// routePattern returns the pattern of the route matching the request, like
+// /todo/{id}
+func routePattern(req *http.Request) string {
+	pattern, _ := req.Context().Value(routePatternContextKey).(string)
+	return pattern
+}
+
+func writeJSONError(rw http.ResponseWriter, status int, message string) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write([]byte(`{"error":"` + message + `"}`))
+}
@@ -0,0 +1,78 @@
+package todo_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestRouteTable_ServeHTTP(t *testing.T) {
+	routes := todo.NewRouteTable()
+	handle := func(name string) func(http.ResponseWriter, *http.Request) {
+		return func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte(name + ":" + todo.PathParam(req, "id")))
+		}
+	}
+	routes.HandleFunc(http.MethodGet, "/things", handle("list"))
+	routes.HandleFunc(http.MethodGet, "/things/{id}", handle("get"))
+	routes.HandleFunc(http.MethodDelete, "/things/{id}", handle("delete"))
+	routes.HandleFunc(http.MethodGet, "/things/special", handle("special"))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	expect := map[string]string{
+		"/things":           "list:",
+		"/things/foo":       "get:foo",
+		"/things/a%2Fb":     "get:a/b",
+		"/things/special":   "special:",
+		"/things/specials":  "get:specials",
+		"/things/%E2%9C%93": "get:✓",
+	}
+	for path, body := range expect {
+		rec := serve(http.MethodGet, path)
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Equal(t, body, rec.Body.String(), path)
+	}
+	assert.Equal(t, "delete:foo", serve(http.MethodDelete, "/things/foo").Body.String())
+
+	// known paths with other methods are not allowed
+	rec := serve(http.MethodPut, "/things/foo")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "DELETE, GET", rec.Header().Get("Allow"))
+	assert.JSONEq(t, `{"error":"method not allowed"}`, rec.Body.String())
+
+	// unknown paths and empty parameters are not found
+	for _, path := range []string{"/", "/other", "/things/", "/things/foo/bar"} {
+		rec = serve(http.MethodGet, path)
+		assert.Equal(t, http.StatusNotFound, rec.Code, path)
+		assert.JSONEq(t, `{"error":"not found"}`, rec.Body.String(), path)
+	}
+
+	assert.Equal(t, "/things/{id}", routes.Pattern("/things/foo"))
+	assert.Equal(t, "/things/special", routes.Pattern("/things/special"))
+	assert.Equal(t, "", routes.Pattern("/other"))
+}
+
+func TestRouter_ServeHTTP_MethodNotAllowed(t *testing.T) {
+	router := testNewRouter()
+	req := httptest.NewRequest(http.MethodPut, "/todo/todo-01", nil)
+	req.SetBasicAuth("the-user", "the-pass")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "DELETE, GET", rec.Header().Get("Allow"))
+
+	// routes of features which are not configured are not found
+	req = httptest.NewRequest(http.MethodGet, "/apikey", nil)
+	req.SetBasicAuth("the-user", "the-pass")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,386 @@
+package todo
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SessionCookieName is the name of the HttpOnly cookie carrying the session ID
+	SessionCookieName = "todo_session"
+
+	// CSRFCookieName is the name of the cookie carrying the CSRF token, which
+	// browser clients must send back in the CSRFHeaderName header
+	CSRFCookieName = "todo_csrf"
+
+	// CSRFHeaderName is the request header carrying the CSRF token
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// Session is a logged in browser client
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	CSRFToken string    `json:"csrf_token"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// SessionStore is a server side storage for sessions
+type SessionStore interface {
+
+	// Create stores a new Session
+	Create(session Session) error
+
+	// Delete removes a Session identified by it's ID. Returns os.ErrNotExist if not found
+	Delete(id string) error
+
+	// Get fetches a Session identified by it's ID. Returns os.ErrNotExist if not found
+	Get(id string) (*Session, error)
+
+	// Update replaces an existing Session
+	Update(session Session) error
+
+	// DeleteExpired removes all sessions for which expired returns true
+	DeleteExpired(expired func(session Session) bool) error
+}
+
+// DefaultSessionLastSeenInterval is the minimum time between updates of the last
+// usage of a session, if the SessionAuthentication has none
+const DefaultSessionLastSeenInterval = time.Minute
+
+// SessionAuthentication identifies users by a session cookie, which is issued on
+// login. State changing requests must carry the CSRF token of the session in the
+// CSRFHeaderName header, which browser clients read from the CSRFCookieName cookie
+// (double submit).
+type SessionAuthentication struct {
+	Store SessionStore
+
+	// IdleTimeout ends sessions which were not used for this long
+	IdleTimeout time.Duration
+
+	// AbsoluteTimeout ends sessions this long after login, used or not
+	AbsoluteTimeout time.Duration
+
+	// LastSeenInterval is the minimum time between updates of LastSeen of a
+	// session, so that stores are not written on every request. Defaults to
+	// DefaultSessionLastSeenInterval and is at most half of IdleTimeout.
+	LastSeenInterval time.Duration
+
+	// Insecure omits the Secure attribute of cookies, for serving plain HTTP in
+	// development only
+	Insecure bool
+
+	// Logger receives failures of Sweep. Defaults to the default of slog.
+	Logger *slog.Logger
+}
+
+// NewSessionAuthentication creates a SessionAuthentication with default timeouts
+func NewSessionAuthentication(store SessionStore) *SessionAuthentication {
+	return &SessionAuthentication{
+		Store:           store,
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 12 * time.Hour,
+	}
+}
+
+// Authenticate returns the ID of the user the session cookie belongs to
+func (a *SessionAuthentication) Authenticate(req *http.Request) (string, error) {
+	session, err := a.session(req)
+	if err != nil {
+		return "", err
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		cookie, err := req.Cookie(CSRFCookieName)
+		header := req.Header.Get(CSRFHeaderName)
+		if err != nil || header == "" ||
+			subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(header), []byte(session.CSRFToken)) != 1 {
+			return "", fmt.Errorf("missing or invalid CSRF token: %w", NotAllowedError)
+		}
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(session.LastSeen) >= a.lastSeenInterval() {
+		session.LastSeen = now
+		if err = a.Store.Update(*session); err != nil {
+			return "", err
+		}
+	}
+
+	return session.UserID, nil
+}
+
+// Login creates a session for the user and sets the cookies
+func (a *SessionAuthentication) Login(rw http.ResponseWriter, req *http.Request, userID string) (*Session, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	session := Session{
+		ID:        id,
+		UserID:    userID,
+		CSRFToken: csrf,
+		Created:   now,
+		LastSeen:  now,
+	}
+	if err = a.Store.Create(session); err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(rw, a.cookie(req, SessionCookieName, session.ID, true))
+	http.SetCookie(rw, a.cookie(req, CSRFCookieName, session.CSRFToken, false))
+
+	return &session, nil
+}
+
+// Logout deletes the session of the request and expires the cookies
+func (a *SessionAuthentication) Logout(rw http.ResponseWriter, req *http.Request) error {
+	cookie, err := req.Cookie(SessionCookieName)
+	if err == nil {
+		if err = a.Store.Delete(cookie.Value); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	for _, name := range []string{SessionCookieName, CSRFCookieName} {
+		expired := a.cookie(req, name, "", name == SessionCookieName)
+		expired.MaxAge = -1
+		http.SetCookie(rw, expired)
+	}
+
+	return nil
+}
+
+// session returns the valid session of the request
+func (a *SessionAuthentication) session(req *http.Request) (*Session, error) {
+	cookie, err := req.Cookie(SessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, MissingCredentialsError
+	}
+
+	session, err := a.Store.Get(cookie.Value)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("unknown session: %w", NotAllowedError)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if a.expired(*session, time.Now()) {
+		if err = a.Store.Delete(session.ID); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("session timed out: %w", NotAllowedError)
+	}
+
+	return session, nil
+}
+
+// Sweep deletes expired sessions from the store in the interval, until stop is
+// closed. Sessions of clients which never return would stay forever otherwise.
+func (a *SessionAuthentication) Sweep(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := a.Store.DeleteExpired(func(session Session) bool {
+				return a.expired(session, now)
+			}); err != nil {
+				loggerOrDefault(a.Logger).Error("delete expired sessions failed", "error", err)
+			}
+		}
+	}
+}
+
+func (a *SessionAuthentication) expired(session Session, now time.Time) bool {
+	return a.IdleTimeout > 0 && now.Sub(session.LastSeen) > a.IdleTimeout ||
+		a.AbsoluteTimeout > 0 && now.Sub(session.Created) > a.AbsoluteTimeout
+}
+
+func (a *SessionAuthentication) lastSeenInterval() time.Duration {
+	interval := a.LastSeenInterval
+	if interval <= 0 {
+		interval = DefaultSessionLastSeenInterval
+	}
+	if a.IdleTimeout > 0 && interval > a.IdleTimeout/2 {
+		interval = a.IdleTimeout / 2
+	}
+	return interval
+}
+
+func (a *SessionAuthentication) cookie(req *http.Request, name, value string, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Secure:   !a.Insecure,
+		HttpOnly: httpOnly,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if a.AbsoluteTimeout > 0 && value != "" {
+		cookie.MaxAge = int(a.AbsoluteTimeout.Seconds())
+	}
+	return cookie
+}
+
+func randomToken() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// MemorySessionStore implements SessionStore in memory, sessions end on restart
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+// NewMemorySessionStore creates an empty MemorySessionStore
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]Session)}
+}
+
+// Create adds the session
+func (s *MemorySessionStore) Create(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.ID] = session
+	return nil
+}
+
+// Delete removes the session
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[id]; !ok {
+		return os.ErrNotExist
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+// Get returns a copy of the session
+func (s *MemorySessionStore) Get(id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &session, nil
+}
+
+// Update replaces the session
+func (s *MemorySessionStore) Update(session Session) error {
+	return s.Create(session)
+}
+
+// DeleteExpired removes the expired sessions
+func (s *MemorySessionStore) DeleteExpired(expired func(session Session) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if expired(session) {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// FileSessionStore implements SessionStore with a local file system directory,
+// so that sessions survive restarts
+type FileSessionStore string
+
+// Create writes the session into <directory>/<hash of id>.json
+func (s FileSessionStore) Create(session Session) error {
+	encoded, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(session.ID), encoded, 0600)
+}
+
+// Delete removes the session file
+func (s FileSessionStore) Delete(id string) error {
+	return os.Remove(s.path(id))
+}
+
+// Get reads the session file
+func (s FileSessionStore) Get(id string) (*Session, error) {
+	encoded, err := ioutil.ReadFile(s.path(id))
+	if err != nil {
+		return nil, err
+	}
+	var session Session
+	if err = json.Unmarshal(encoded, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Update rewrites the session file
+func (s FileSessionStore) Update(session Session) error {
+	return s.Create(session)
+}
+
+// DeleteExpired removes the files of expired sessions. Files which can't be read
+// as a session are left alone.
+func (s FileSessionStore) DeleteExpired(expired func(session Session) bool) error {
+	files, err := ioutil.ReadDir(string(s))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(string(s), file.Name())
+		encoded, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var session Session
+		if err = json.Unmarshal(encoded, &session); err != nil || !expired(session) {
+			continue
+		}
+		if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// path uses a hash of the session ID as file name, so that listing the directory
+// does not reveal usable session IDs and IDs can't traverse paths
+func (s FileSessionStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(string(s), hex.EncodeToString(sum[:])+".json")
+}
@@ -0,0 +1,193 @@
+package todo_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestSessionAuthentication_Authenticate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stores := map[string]todo.SessionStore{
+		"memory": todo.NewMemorySessionStore(),
+		"file":   todo.FileSessionStore(dir),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			auth := todo.NewSessionAuthentication(store)
+			cookies := createTestSession(t, auth, "u01")
+			csrf := cookieValue(cookies, todo.CSRFCookieName)
+
+			expects := []struct {
+				name    string
+				request *http.Request
+				id      string
+				err     error
+			}{
+				{"missing cookie", createSessionTestRequest(http.MethodGet, nil, ""), "", todo.MissingCredentialsError},
+				{"unknown session", createSessionTestRequest(http.MethodGet, []*http.Cookie{{Name: todo.SessionCookieName, Value: "foo"}}, ""), "", todo.NotAllowedError},
+				{"write without csrf token", createSessionTestRequest(http.MethodPost, cookies, ""), "", todo.NotAllowedError},
+				{"write with wrong csrf token", createSessionTestRequest(http.MethodPost, cookies, "foo"), "", todo.NotAllowedError},
+				{"allow read", createSessionTestRequest(http.MethodGet, cookies, ""), "u01", nil},
+				{"allow write with csrf token", createSessionTestRequest(http.MethodDelete, cookies, csrf), "u01", nil},
+			}
+
+			for _, expect := range expects {
+				t.Run(expect.name, func(t *testing.T) {
+					userID, err := auth.Authenticate(expect.request)
+					if expect.err == nil {
+						assert.NoError(t, err)
+						assert.Equal(t, expect.id, userID)
+					} else {
+						assert.True(t, errors.Is(err, expect.err), "expected %s, got %v", expect.err, err)
+					}
+				})
+			}
+
+			// logout ends the session
+			rec := httptest.NewRecorder()
+			require.NoError(t, auth.Logout(rec, createSessionTestRequest(http.MethodPost, cookies, csrf)))
+			_, err := auth.Authenticate(createSessionTestRequest(http.MethodGet, cookies, ""))
+			assert.True(t, errors.Is(err, todo.NotAllowedError))
+		})
+	}
+}
+
+func TestSessionAuthentication_Authenticate_Timeouts(t *testing.T) {
+	auth := todo.NewSessionAuthentication(todo.NewMemorySessionStore())
+	auth.IdleTimeout = 50 * time.Millisecond
+	auth.AbsoluteTimeout = 150 * time.Millisecond
+
+	// idle
+	cookies := createTestSession(t, auth, "u01")
+	time.Sleep(60 * time.Millisecond)
+	_, err := auth.Authenticate(createSessionTestRequest(http.MethodGet, cookies, ""))
+	assert.True(t, errors.Is(err, todo.NotAllowedError))
+
+	// absolute, even if used
+	cookies = createTestSession(t, auth, "u01")
+	for i := 0; i < 4; i++ {
+		time.Sleep(40 * time.Millisecond)
+		_, err = auth.Authenticate(createSessionTestRequest(http.MethodGet, cookies, ""))
+	}
+	assert.True(t, errors.Is(err, todo.NotAllowedError))
+}
+
+func TestSessionAuthentication_Authenticate_LastSeenInterval(t *testing.T) {
+	store := &testCountingSessionStore{SessionStore: todo.NewMemorySessionStore()}
+	auth := todo.NewSessionAuthentication(store)
+	auth.LastSeenInterval = 50 * time.Millisecond
+	cookies := createTestSession(t, auth, "u01")
+
+	for i := 0; i < 3; i++ {
+		_, err := auth.Authenticate(createSessionTestRequest(http.MethodGet, cookies, ""))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 0, store.updates)
+
+	time.Sleep(60 * time.Millisecond)
+	_, err := auth.Authenticate(createSessionTestRequest(http.MethodGet, cookies, ""))
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.updates)
+}
+
+func TestSessionAuthentication_Sweep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte("not a session"), 0600))
+
+	stores := map[string]todo.SessionStore{
+		"memory": todo.NewMemorySessionStore(),
+		"file":   todo.FileSessionStore(dir),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			auth := todo.NewSessionAuthentication(store)
+			auth.IdleTimeout = 30 * time.Millisecond
+			rec := httptest.NewRecorder()
+			session, err := auth.Login(rec, httptest.NewRequest(http.MethodPost, "/auth/login", nil), "u01")
+			require.NoError(t, err)
+
+			stop := make(chan struct{})
+			defer close(stop)
+			go auth.Sweep(10*time.Millisecond, stop)
+
+			// sessions are deleted without being used again
+			require.Eventually(t, func() bool {
+				_, err := store.Get(session.ID)
+				return errors.Is(err, os.ErrNotExist)
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "other.json"))
+	assert.NoError(t, err, "files which are no sessions are kept")
+}
+
+func TestSessionAuthentication_Login(t *testing.T) {
+	auth := todo.NewSessionAuthentication(todo.NewMemorySessionStore())
+	rec := httptest.NewRecorder()
+	_, err := auth.Login(rec, httptest.NewRequest(http.MethodPost, "/auth/login", nil), "u01")
+	require.NoError(t, err)
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 2)
+	for _, cookie := range cookies {
+		assert.True(t, cookie.Secure)
+		assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+		assert.Equal(t, cookie.Name == todo.SessionCookieName, cookie.HttpOnly)
+	}
+}
+
+func createTestSession(t *testing.T, auth *todo.SessionAuthentication, userID string) []*http.Cookie {
+	rec := httptest.NewRecorder()
+	_, err := auth.Login(rec, httptest.NewRequest(http.MethodPost, "/auth/login", nil), userID)
+	require.NoError(t, err)
+	return rec.Result().Cookies()
+}
+
+func createSessionTestRequest(method string, cookies []*http.Cookie, csrf string) *http.Request {
+	req := httptest.NewRequest(method, "http://localhost:12345/bla", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	if csrf != "" {
+		req.Header.Set(todo.CSRFHeaderName, csrf)
+	}
+	return req
+}
+
+func cookieValue(cookies []*http.Cookie, name string) string {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// testCountingSessionStore counts updates of sessions
+type testCountingSessionStore struct {
+	todo.SessionStore
+	updates int
+}
+
+func (s *testCountingSessionStore) Update(session todo.Session) error {
+	s.updates++
+	return s.SessionStore.Update(session)
+}
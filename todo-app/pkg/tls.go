@@ -0,0 +1,131 @@
+package todo
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertificateReloader provides a certificate and key from PEM files to TLS servers
+// (see tls.Config.GetCertificate). The files are loaded again when they change,
+// like after renewal, without restarting the server.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	// CheckInterval is the minimum time between two checks of the files
+	CheckInterval time.Duration
+
+	// Logger receives reloads and their failures. Defaults to the default of slog.
+	Logger *slog.Logger
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+	checked  time.Time
+}
+
+// NewCertificateReloader loads the certificate and key from the files
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		CheckInterval: 10 * time.Second,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, after reloading the files if
+// they changed since the last load
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checked) >= r.CheckInterval {
+		r.checked = now
+		if modified := r.lastModified(); modified.After(r.modified) {
+			if err := r.load(); err != nil {
+				loggerOrDefault(r.Logger).Error("reload certificate failed, keeping previous", "file", r.certFile, "error", err)
+			}
+		}
+	}
+
+	return r.cert, nil
+}
+
+// load must be called with the lock held, or before the reloader is used
+func (r *CertificateReloader) load() error {
+	modified := r.lastModified()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil {
+		loggerOrDefault(r.Logger).Info("reloaded certificate", "file", r.certFile)
+	}
+	r.cert = &cert
+	r.modified = modified
+	return nil
+}
+
+// lastModified returns the latest modification time of certificate and key file
+func (r *CertificateReloader) lastModified() time.Time {
+	var modified time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified
+}
+
+// NewTLSConfig returns a server configuration with modern defaults: TLS 1.2 or
+// newer, only forward secret AEAD cipher suites and HTTP/2
+func NewTLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		GetCertificate: getCertificate,
+		MinVersion:     tls.VersionTLS12,
+		CipherSuites: []uint16{
+			// only applies to TLS 1.2, TLS 1.3 suites are all fine
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		NextProtos:       []string{"h2", "http/1.1"},
+	}
+}
+
+// HTTPSRedirect redirects plain HTTP requests to the same URL with HTTPS on the
+// host of the request and the port of the HTTPS address
+type HTTPSRedirect struct {
+
+	// Address is the listen address of the HTTPS server
+	Address string
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h HTTPSRedirect) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if hostOnly, _, err := net.SplitHostPort(host); err == nil {
+		host = hostOnly
+	}
+	if _, port, err := net.SplitHostPort(h.Address); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	http.Redirect(rw, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+}
@@ -0,0 +1,140 @@
+package todo_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestCertificateReloader_GetCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	ca := newTestCA(t, "test ca")
+	ca.WriteServerCert(t, 10, certFile, keyFile, time.Now().Add(-time.Minute))
+
+	reloader, err := todo.NewCertificateReloader(certFile, keyFile)
+	require.NoError(t, err)
+	reloader.CheckInterval = 0
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(10), cert.Leaf.SerialNumber)
+
+	// rotated files are picked up
+	ca.WriteServerCert(t, 11, certFile, keyFile, time.Now())
+	cert, err = reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(11), cert.Leaf.SerialNumber)
+
+	// broken files keep the previous certificate
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("broken"), 0600))
+	modified := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyFile, modified, modified))
+	cert, err = reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(11), cert.Leaf.SerialNumber)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	ca := newTestCA(t, "test ca")
+	ca.WriteServerCert(t, 10, certFile, keyFile, time.Now())
+	reloader, err := todo.NewCertificateReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte(req.Proto))
+		}),
+		TLSConfig: todo.NewTLSConfig(reloader.GetCertificate),
+	}
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	get := func(config *tls.Config) (*http.Response, error) {
+		config.RootCAs = roots
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true}}
+		return client.Get("https://" + listener.Addr().String())
+	}
+
+	// HTTP/2 is negotiated
+	res, err := get(&tls.Config{})
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, 2, res.ProtoMajor)
+
+	// old protocol versions are rejected
+	_, err = get(&tls.Config{MaxVersion: tls.VersionTLS11})
+	assert.Error(t, err)
+}
+
+func TestHTTPSRedirect_ServeHTTP(t *testing.T) {
+	expects := []struct {
+		address  string
+		url      string
+		location string
+	}{
+		{":443", "http://example.com/v1/todo?a=b", "https://example.com/v1/todo?a=b"},
+		{"0.0.0.0:8443", "http://example.com:8080/v1/todo", "https://example.com:8443/v1/todo"},
+	}
+
+	for _, expect := range expects {
+		rec := httptest.NewRecorder()
+		todo.HTTPSRedirect{Address: expect.address}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, expect.url, nil))
+		assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+		assert.Equal(t, expect.location, rec.Header().Get("Location"))
+	}
+}
+
+// WriteServerCert issues a server certificate for 127.0.0.1 and writes it with
+// it's key as PEM files
+func (ca *testCA) WriteServerCert(t *testing.T, serial int64, certFile, keyFile string, modified time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, key.Public(), ca.Key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	for _, file := range []string{certFile, keyFile} {
+		require.NoError(t, os.Chtimes(file, modified, modified))
+	}
+}
@@ -2,7 +2,17 @@ package todo
 
 import (
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTitleLength is the maximum number of characters of Todo titles
+	MaxTitleLength = 200
+
+	// MaxDescriptionLength is the maximum number of characters of Todo descriptions
+	MaxDescriptionLength = 10000
 )
 
 type Todo struct {
@@ -16,3 +26,16 @@ type Todo struct {
 func (t Todo) String() string {
 	return fmt.Sprintf("[%s] %s", t.Created, t.Title)
 }
+
+// Validate returns an InvalidRequestError, if the title is missing or fields
+// exceed their length limits
+func (t Todo) Validate() error {
+	if strings.TrimSpace(t.Title) == "" {
+		return invalidRequest("title required")
+	} else if utf8.RuneCountInString(t.Title) > MaxTitleLength {
+		return invalidRequest("title exceeds %d characters", MaxTitleLength)
+	} else if utf8.RuneCountInString(t.Description) > MaxDescriptionLength {
+		return invalidRequest("description exceeds %d characters", MaxDescriptionLength)
+	}
+	return nil
+}
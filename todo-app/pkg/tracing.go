@@ -0,0 +1,480 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// TraceparentHeader is the W3C trace context header, which propagates the trace
+// of a request across services
+const TraceparentHeader = "traceparent"
+
+// SpanKind is the role of a span in a trace
+type SpanKind string
+
+// Span kinds
+const (
+	SpanKindInternal SpanKind = "internal"
+	SpanKindServer   SpanKind = "server"
+)
+
+// Span is a timed operation within a trace, like handling a request or reading
+// from Persistence
+type Span struct {
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentID      string                 `json:"parent_id,omitempty"`
+	Name          string                 `json:"name"`
+	Kind          SpanKind               `json:"kind"`
+	Start         time.Time              `json:"start"`
+	End           time.Time              `json:"end"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Status        string                 `json:"status,omitempty"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+
+	sampled bool
+	tracer  *Tracer
+}
+
+// SetAttribute adds a key value pair to the span. Does nothing on a nil span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{})
+	}
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed, if err is not nil. Does nothing on a nil span.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Status = "error"
+	s.StatusMessage = err.Error()
+}
+
+// Finish ends the span and hands it to the exporter of the Tracer, if the trace
+// is sampled. Does nothing on a nil span.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	if s.Status == "" {
+		s.Status = "ok"
+	}
+	if s.sampled {
+		s.tracer.enqueue(*s)
+	}
+}
+
+// Traceparent returns the W3C traceparent header value to propagate the span
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+// SpanFromContext returns the current span of the context, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey).(*Span)
+	return span
+}
+
+// StartSpan starts a child span of the current span of the context, with the same
+// Tracer. Returns a nil span, which is safe to use, if the context is not traced.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		TraceID:  parent.TraceID,
+		SpanID:   randomHex(8),
+		ParentID: parent.SpanID,
+		Name:     name,
+		Kind:     SpanKindInternal,
+		Start:    time.Now(),
+		sampled:  parent.sampled,
+		tracer:   parent.tracer,
+	}
+	return context.WithValue(ctx, spanContextKey, span), span
+}
+
+// traceparentPattern matches version 00 of the W3C traceparent header
+var traceparentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// ParseTraceparent returns trace ID, parent span ID and whether the trace is
+// sampled from a W3C traceparent header value
+func ParseTraceparent(value string) (traceID, parentID string, sampled bool, ok bool) {
+	match := traceparentPattern.FindStringSubmatch(value)
+	if match == nil || match[1] == "00000000000000000000000000000000" || match[2] == "0000000000000000" {
+		return "", "", false, false
+	}
+	flags, _ := strconv.ParseUint(match[3], 16, 8)
+	return match[1], match[2], flags&1 == 1, true
+}
+
+// SpanExporter sends finished spans to a tracing backend
+type SpanExporter interface {
+	Export(spans []Span) error
+}
+
+// TracerQueueSize is the number of finished spans waiting for export, further
+// spans are dropped until the exporter catches up
+const TracerQueueSize = 4096
+
+// Tracer starts traces for incoming requests and exports finished spans in
+// batches, in the background
+type Tracer struct {
+	Exporter SpanExporter
+
+	// BatchSize is the number of spans after which they are exported, without
+	// waiting for the next interval
+	BatchSize int
+
+	// Logger receives dropped spans and failed exports. Defaults to the default
+	// of slog.
+	Logger *slog.Logger
+
+	spans   chan Span
+	flush   chan chan error
+	dropped atomic.Int64
+	stop    chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+// NewTracer creates a Tracer, which exports spans at least once per interval
+// until closed
+func NewTracer(exporter SpanExporter, interval time.Duration) *Tracer {
+	t := &Tracer{
+		Exporter:  exporter,
+		BatchSize: 512,
+		spans:     make(chan Span, TracerQueueSize),
+		flush:     make(chan chan error),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go t.run(interval)
+	return t
+}
+
+// Start starts a new trace with a root span, or continues the trace of the
+// remote parent from a traceparent header value
+func (t *Tracer) Start(ctx context.Context, name string, traceparent string) (context.Context, *Span) {
+	span := &Span{
+		TraceID: randomHex(16),
+		SpanID:  randomHex(8),
+		Name:    name,
+		Kind:    SpanKindInternal,
+		Start:   time.Now(),
+		sampled: true,
+		tracer:  t,
+	}
+	if traceID, parentID, sampled, ok := ParseTraceparent(traceparent); ok {
+		span.TraceID, span.ParentID, span.sampled = traceID, parentID, sampled
+	}
+	return context.WithValue(ctx, spanContextKey, span), span
+}
+
+// Middleware traces each request in a server span, named by method and the route
+// template returned by route, as child of the traceparent header of the request
+func (t *Tracer) Middleware(next http.Handler, route func(req *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		template := route(req)
+		ctx, span := t.Start(req.Context(), req.Method+" "+template, req.Header.Get(TraceparentHeader))
+		span.Kind = SpanKindServer
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.route", template)
+		span.SetAttribute("http.target", RedactURL(req.URL))
+
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(recorder, req.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("status %d", recorder.status))
+		}
+		span.Finish()
+	})
+}
+
+// Flush exports all queued spans
+func (t *Tracer) Flush() error {
+	reply := make(chan error, 1)
+	select {
+	case t.flush <- reply:
+		return <-reply
+	case <-t.done:
+		return nil
+	}
+}
+
+// Close stops the background export and exports all queued spans
+func (t *Tracer) Close() error {
+	close(t.stop)
+	<-t.done
+	return t.err
+}
+
+// enqueue hands the span to the exporting goroutine, or drops it if the queue
+// is full, so that requests never wait for a slow exporter
+func (t *Tracer) enqueue(span Span) {
+	select {
+	case t.spans <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run collects spans into batches and exports them when full, in the interval,
+// on Flush and on Close. It is the only goroutine exporting.
+func (t *Tracer) run(interval time.Duration) {
+	defer close(t.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// export sends the batch, after adding all queued spans if drain is set
+	var batch []Span
+	export := func(drain bool) error {
+		if dropped := t.dropped.Swap(0); dropped > 0 {
+			loggerOrDefault(t.Logger).Warn("dropped spans, export queue full", "count", dropped)
+		}
+		for drained := !drain; !drained; {
+			select {
+			case span := <-t.spans:
+				batch = append(batch, span)
+			default:
+				drained = true
+			}
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		spans := batch
+		batch = nil
+		return t.Exporter.Export(spans)
+	}
+	exportLogged := func() {
+		if err := export(false); err != nil {
+			loggerOrDefault(t.Logger).Error("export spans failed", "error", err)
+		}
+	}
+
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if t.BatchSize > 0 && len(batch) >= t.BatchSize {
+				exportLogged()
+			}
+		case <-ticker.C:
+			exportLogged()
+		case reply := <-t.flush:
+			reply <- export(true)
+		case <-t.stop:
+			t.err = export(true)
+			return
+		}
+	}
+}
+
+func randomHex(size int) string {
+	random := make([]byte, size)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(random)
+}
+
+// StdoutExporter writes each span as a JSON line, like to stdout
+type StdoutExporter struct {
+	Out io.Writer
+}
+
+// Export writes the spans
+func (e StdoutExporter) Export(spans []Span) error {
+	encoder := json.NewEncoder(e.Out)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector, with the OTLP/HTTP
+// protocol in JSON encoding
+type OTLPExporter struct {
+
+	// Endpoint is the URL of the traces endpoint, like http://localhost:4318/v1/traces
+	Endpoint string
+
+	// ServiceName identifies this server in traces
+	ServiceName string
+
+	// Headers are sent with each export, like for authentication
+	Headers map[string]string
+
+	Client *http.Client
+}
+
+// Export posts the spans to the collector
+func (e OTLPExporter) Export(spans []Span) error {
+	otlpSpans := make([]map[string]interface{}, len(spans))
+	for i, span := range spans {
+		otlpSpans[i] = otlpSpan(span)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": e.ServiceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/ukautz/go-intro/todo-app"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func otlpSpan(span Span) map[string]interface{} {
+	kind := 1
+	if span.Kind == SpanKindServer {
+		kind = 2
+	}
+	status := map[string]interface{}{"code": 1}
+	if span.Status == "error" {
+		status = map[string]interface{}{"code": 2, "message": span.StatusMessage}
+	}
+	encoded := map[string]interface{}{
+		"traceId":           span.TraceID,
+		"spanId":            span.SpanID,
+		"name":              span.Name,
+		"kind":              kind,
+		"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+		"attributes":        otlpAttributes(span.Attributes),
+		"status":            status,
+	}
+	if span.ParentID != "" {
+		encoded["parentSpanId"] = span.ParentID
+	}
+	return encoded
+}
+
+func otlpAttributes(attributes map[string]interface{}) []interface{} {
+	encoded := make([]interface{}, 0, len(attributes))
+	for key, value := range attributes {
+		var otlpValue map[string]interface{}
+		switch v := value.(type) {
+		case bool:
+			otlpValue = map[string]interface{}{"boolValue": v}
+		case int:
+			otlpValue = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			otlpValue = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			otlpValue = map[string]interface{}{"doubleValue": v}
+		default:
+			otlpValue = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, map[string]interface{}{"key": key, "value": otlpValue})
+	}
+	return encoded
+}
+
+// TracedPersistence records a span for each call to a ContextPersistence, as
+// child of the current span of the context
+type TracedPersistence struct {
+	Persistence ContextPersistence
+}
+
+// CreateContext traces ContextPersistence.CreateContext
+func (p TracedPersistence) CreateContext(ctx context.Context, todo Todo) (string, error) {
+	ctx, span := StartSpan(ctx, "persistence.create")
+	defer span.Finish()
+	id, err := p.Persistence.CreateContext(ctx, todo)
+	span.SetAttribute("todo.id", id)
+	span.SetError(err)
+	return id, err
+}
+
+// DeleteContext traces ContextPersistence.DeleteContext
+func (p TracedPersistence) DeleteContext(ctx context.Context, id string) error {
+	ctx, span := StartSpan(ctx, "persistence.delete")
+	defer span.Finish()
+	span.SetAttribute("todo.id", id)
+	err := p.Persistence.DeleteContext(ctx, id)
+	span.SetError(err)
+	return err
+}
+
+// GetContext traces ContextPersistence.GetContext
+func (p TracedPersistence) GetContext(ctx context.Context, id string) (*Todo, error) {
+	ctx, span := StartSpan(ctx, "persistence.get")
+	defer span.Finish()
+	span.SetAttribute("todo.id", id)
+	todo, err := p.Persistence.GetContext(ctx, id)
+	span.SetError(err)
+	return todo, err
+}
+
+// ListContext traces ContextPersistence.ListContext
+func (p TracedPersistence) ListContext(ctx context.Context) ([]Todo, error) {
+	ctx, span := StartSpan(ctx, "persistence.list")
+	defer span.Finish()
+	todos, err := p.Persistence.ListContext(ctx)
+	span.SetAttribute("todo.count", len(todos))
+	span.SetError(err)
+	return todos, err
+}
@@ -0,0 +1,269 @@
+package todo_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+type testSpanExporter struct {
+	mu    sync.Mutex
+	spans []todo.Span
+}
+
+func (e *testSpanExporter) Export(spans []todo.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *testSpanExporter) byName() map[string]todo.Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	spans := make(map[string]todo.Span)
+	for _, span := range e.spans {
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+func TestTracer_Middleware(t *testing.T) {
+	exporter := &testSpanExporter{}
+	tracer := todo.NewTracer(exporter, time.Hour)
+	router := testNewRouter()
+	handler := tracer.Middleware(router, router.Route)
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/todo/todo-01", nil)
+	req.SetBasicAuth("the-user", "the-pass")
+	req.Header.Set(todo.TraceparentHeader, "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, tracer.Close())
+
+	spans := exporter.byName()
+	require.Len(t, spans, 3)
+	server, auth, get := spans["GET /todo/{id}"], spans["authenticate"], spans["persistence.get"]
+
+	assert.Equal(t, todo.SpanKindServer, server.Kind)
+	assert.Equal(t, "00f067aa0ba902b7", server.ParentID)
+	assert.Equal(t, 200, server.Attributes["http.status_code"])
+	assert.Equal(t, "/todo/{id}", server.Attributes["http.route"])
+	assert.Equal(t, "ok", server.Status)
+
+	for _, span := range []todo.Span{server, auth, get} {
+		assert.Equal(t, traceID, span.TraceID)
+		assert.False(t, span.End.Before(span.Start))
+	}
+	assert.Equal(t, server.SpanID, auth.ParentID)
+	assert.Equal(t, "the-user", auth.Attributes["user.id"])
+	assert.Equal(t, server.SpanID, get.ParentID)
+	assert.Equal(t, "todo-01", get.Attributes["todo.id"])
+}
+
+func TestTracer_Middleware_NotSampled(t *testing.T) {
+	exporter := &testSpanExporter{}
+	tracer := todo.NewTracer(exporter, time.Hour)
+	router := testNewRouter()
+	handler := tracer.Middleware(router, router.Route)
+
+	req := httptest.NewRequest(http.MethodGet, "/todo/missing", nil)
+	req.SetBasicAuth("the-user", "the-pass")
+	req.Header.Set(todo.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	require.NoError(t, tracer.Close())
+	assert.Empty(t, exporter.byName())
+}
+
+func TestTracer_Middleware_NewTrace(t *testing.T) {
+	exporter := &testSpanExporter{}
+	tracer := todo.NewTracer(exporter, time.Hour)
+	router := testNewRouter()
+	handler := tracer.Middleware(router, router.Route)
+
+	// invalid traceparent starts a new trace, errors are recorded
+	req := httptest.NewRequest(http.MethodGet, "/todo/missing", nil)
+	req.SetBasicAuth("the-user", "the-pass")
+	req.Header.Set(todo.TraceparentHeader, "garbage")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	require.NoError(t, tracer.Close())
+
+	spans := exporter.byName()
+	server := spans["GET /todo/{id}"]
+	assert.Len(t, server.TraceID, 32)
+	assert.Empty(t, server.ParentID)
+	assert.Equal(t, "error", server.Status)
+	assert.Equal(t, "error", spans["persistence.get"].Status)
+	assert.Equal(t, "not found", spans["persistence.get"].StatusMessage)
+}
+
+func TestTracer_Batches(t *testing.T) {
+	exporter := &testBlockingSpanExporter{release: make(chan struct{})}
+	tracer := todo.NewTracer(exporter, time.Hour)
+	tracer.BatchSize = 10
+
+	// a slow exporter neither blocks finishing spans nor piles up exports
+	total := todo.TracerQueueSize + 100
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for i := 0; i < total; i++ {
+			_, span := tracer.Start(context.Background(), "span", "")
+			span.Finish()
+		}
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("finishing spans blocked by exporter")
+	}
+	close(exporter.release)
+	require.NoError(t, tracer.Close())
+
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	assert.Equal(t, 1, exporter.maxActive)
+	assert.Less(t, exporter.exported, total, "spans beyond the queue are dropped")
+	assert.GreaterOrEqual(t, exporter.exported, todo.TracerQueueSize)
+}
+
+func TestTracer_Flush(t *testing.T) {
+	exporter := &testSpanExporter{}
+	tracer := todo.NewTracer(exporter, time.Hour)
+	_, span := tracer.Start(context.Background(), "span", "")
+	span.Finish()
+
+	require.NoError(t, tracer.Flush())
+	assert.Len(t, exporter.byName(), 1)
+	require.NoError(t, tracer.Close())
+	assert.NoError(t, tracer.Flush(), "flush after close does nothing")
+}
+
+// testBlockingSpanExporter counts exported spans and concurrent exports, which
+// wait until release is closed
+type testBlockingSpanExporter struct {
+	release   chan struct{}
+	mu        sync.Mutex
+	active    int
+	maxActive int
+	exported  int
+}
+
+func (e *testBlockingSpanExporter) Export(spans []todo.Span) error {
+	e.mu.Lock()
+	e.active++
+	if e.active > e.maxActive {
+		e.maxActive = e.active
+	}
+	e.mu.Unlock()
+	<-e.release
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.active--
+	e.exported += len(spans)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, parentID, sampled, ok := todo.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.True(t, sampled)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, "00f067aa0ba902b7", parentID)
+
+	for _, invalid := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+	} {
+		_, _, _, ok = todo.ParseTraceparent(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestStdoutExporter_Export(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := todo.StdoutExporter{Out: out}.Export([]todo.Span{{TraceID: "t1", SpanID: "s1", Name: "one"}, {TraceID: "t1", SpanID: "s2", Name: "two"}})
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	var span map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[1], &span))
+	assert.Equal(t, "two", span["name"])
+	assert.Equal(t, "s2", span["span_id"])
+}
+
+func TestOTLPExporter_Export(t *testing.T) {
+	var received map[string]interface{}
+	var header http.Header
+	collector := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		header = req.Header
+		body, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(body, &received)
+		rw.Write([]byte(`{}`))
+	}))
+	defer collector.Close()
+
+	start := time.Unix(1600000000, 0)
+	err := todo.OTLPExporter{
+		Endpoint:    collector.URL + "/v1/traces",
+		ServiceName: "todo-test",
+		Headers:     map[string]string{"x-api-key": "k"},
+	}.Export([]todo.Span{{
+		TraceID:       "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:        "00f067aa0ba902b7",
+		ParentID:      "1111111111111111",
+		Name:          "GET /todo",
+		Kind:          todo.SpanKindServer,
+		Start:         start,
+		End:           start.Add(time.Millisecond),
+		Attributes:    map[string]interface{}{"http.status_code": 500},
+		Status:        "error",
+		StatusMessage: "status 500",
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", header.Get("content-type"))
+	assert.Equal(t, "k", header.Get("x-api-key"))
+
+	expect := `{"resourceSpans":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"todo-test"}}]},
+		"scopeSpans":[{
+			"scope":{"name":"github.com/ukautz/go-intro/todo-app"},
+			"spans":[{
+				"traceId":"4bf92f3577b34da6a3ce929d0e0e4736",
+				"spanId":"00f067aa0ba902b7",
+				"parentSpanId":"1111111111111111",
+				"name":"GET /todo",
+				"kind":2,
+				"startTimeUnixNano":"1600000000000000000",
+				"endTimeUnixNano":"1600000000001000000",
+				"attributes":[{"key":"http.status_code","value":{"intValue":"500"}}],
+				"status":{"code":2,"message":"status 500"}
+			}]
+		}]
+	}]}`
+	encoded, _ := json.Marshal(received)
+	assert.JSONEq(t, expect, string(encoded))
+
+	// failures of the collector are reported
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	assert.Error(t, todo.OTLPExporter{Endpoint: failing.URL}.Export([]todo.Span{{Name: "x"}}))
+}
@@ -1,8 +1,135 @@
 package todo
 
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is a user of the API. Password contains a bcrypt hash of the password,
+// see SetPassword. Plaintext passwords of older users files are still accepted.
 type User struct {
 	ID       string `json:"id"`
 	Name     string `json:"name"`
 	Password string `json:"pass"`
+	Admin    bool   `json:"admin,omitempty"`
+}
+
+// DuplicateUserError is returned when a user name or ID is already taken
+var DuplicateUserError = errors.New("user already exists")
+
+// LastAdminError is returned when the last admin would be deleted or demoted,
+// which leaves nobody to manage users
+var LastAdminError = errors.New("last admin")
+
+// dummyPasswordHash is compared against for unknown users, so that the response
+// time doesn't tell whether a user exists
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// SetPassword replaces the password with a bcrypt hash of it
+func (u *User) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return invalidRequest("password longer than 72 bytes")
+	} else if err != nil {
+		return err
+	}
+	u.Password = string(hash)
+	return nil
+}
+
+// CheckPassword returns whether the password matches. Users without password,
+// like auto provisioned ones, have no matching password.
+func (u User) CheckPassword(password string) bool {
+	if u.Password == "" {
+		return false
+	} else if u.PasswordHashed() {
+		return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) == 1
 }
 
+// PasswordHashed returns whether the password is stored as bcrypt hash, or as
+// plaintext, which needs to be changed
+func (u User) PasswordHashed() bool {
+	return strings.HasPrefix(u.Password, "$2a$") || strings.HasPrefix(u.Password, "$2b$") ||
+		strings.HasPrefix(u.Password, "$2y$")
+}
+
+// UserStore is a storage for users
+type UserStore interface {
+
+	// Create stores a new User and returns the ID, which is generated if empty
+	Create(user User) (string, error)
+
+	// Delete removes a single User identified by it's ID. Returns os.ErrNotExist if not found
+	Delete(id string) error
+
+	// Get fetches a single User identified by it's ID. Returns os.ErrNotExist if not found
+	Get(id string) (*User, error)
+
+	// List returns all Users
+	List() ([]User, error)
+
+	// Update replaces an existing User. Returns os.ErrNotExist if not found
+	Update(user User) error
+}
+
+// FindUserByName returns the user with the name from the store. Returns
+// os.ErrNotExist if not found
+func FindUserByName(store UserStore, name string) (*User, error) {
+	users, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if user.Name == name {
+			return &user, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// CheckLastAdmin returns LastAdminError, if the user with the ID is the only
+// admin of the store
+func CheckLastAdmin(store UserStore, userID string) error {
+	users, err := store.List()
+	if err != nil {
+		return err
+	}
+	others, last := 0, false
+	for _, user := range users {
+		if user.Admin && user.ID == userID {
+			last = true
+		} else if user.Admin {
+			others++
+		}
+	}
+	if last && others == 0 {
+		return fmt.Errorf("user %s is the only admin: %w", userID, LastAdminError)
+	}
+	return nil
+}
+
+// validateUsers assures that all users have ID and name, which are unique, or
+// users could act as each other
+func validateUsers(users []User) error {
+	ids := make(map[string]bool)
+	names := make(map[string]bool)
+	for i, user := range users {
+		if user.ID == "" || user.Name == "" {
+			return invalidRequest("user #%d without id or name", i+1)
+		} else if ids[user.ID] {
+			return fmt.Errorf("duplicate id %s: %w", user.ID, DuplicateUserError)
+		} else if names[user.Name] {
+			return fmt.Errorf("duplicate name %s: %w", user.Name, DuplicateUserError)
+		}
+		ids[user.ID] = true
+		names[user.Name] = true
+	}
+	return nil
+}
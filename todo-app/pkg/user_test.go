@@ -0,0 +1,45 @@
+package todo_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+)
+
+func TestUser_SetPassword(t *testing.T) {
+	user := todo.User{ID: "u01", Name: "alice"}
+	assert.False(t, user.CheckPassword(""), "users without password can't login")
+
+	require.NoError(t, user.SetPassword("secret1"))
+	assert.True(t, user.PasswordHashed())
+	assert.NotContains(t, user.Password, "secret1")
+	assert.True(t, user.CheckPassword("secret1"))
+	assert.False(t, user.CheckPassword("secret2"))
+	assert.False(t, user.CheckPassword(""))
+
+	err := user.SetPassword(strings.Repeat("x", 73))
+	assert.True(t, errors.Is(err, todo.InvalidRequestError))
+
+	// plaintext passwords of older users files
+	legacy := todo.User{ID: "u02", Name: "bob", Password: "secret2"}
+	assert.False(t, legacy.PasswordHashed())
+	assert.True(t, legacy.CheckPassword("secret2"))
+	assert.False(t, legacy.CheckPassword("secret"))
+}
+
+func TestCheckLastAdmin(t *testing.T) {
+	store := &testUserStore{
+		{ID: "u01", Name: "alice", Admin: true},
+		{ID: "u02", Name: "bob"},
+	}
+	assert.True(t, errors.Is(todo.CheckLastAdmin(store, "u01"), todo.LastAdminError))
+	assert.NoError(t, todo.CheckLastAdmin(store, "u02"))
+	assert.NoError(t, todo.CheckLastAdmin(store, "unknown"))
+
+	(*store)[1].Admin = true
+	assert.NoError(t, todo.CheckLastAdmin(store, "u01"))
+}
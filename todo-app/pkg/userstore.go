@@ -0,0 +1,334 @@
+package todo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JSONFileUserStore implements UserStore with a JSON file containing a list of
+// users. The file can be reloaded while serving requests, a file which fails to
+// load keeps the previous users in place.
+type JSONFileUserStore struct {
+
+	// Logger receives reloads and their failures. Defaults to the default of slog.
+	Logger *slog.Logger
+
+	filename string
+	users    []User
+	mu       sync.RWMutex
+	modified time.Time
+	size     int64
+}
+
+// NewJSONFileUserStore loads users from the JSON file
+func NewJSONFileUserStore(filename string) (*JSONFileUserStore, error) {
+	s := &JSONFileUserStore{filename: filename}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create adds the user and writes the file
+func (s *JSONFileUserStore) Create(user User) (string, error) {
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := append(append([]User{}, s.users...), user)
+	return user.ID, s.write(users)
+}
+
+// Delete removes the user and writes the file
+func (s *JSONFileUserStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		if user.ID != id {
+			users = append(users, user)
+		}
+	}
+	if len(users) == len(s.users) {
+		return os.ErrNotExist
+	}
+	return s.write(users)
+}
+
+// Get returns a copy of the user
+func (s *JSONFileUserStore) Get(id string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, user := range s.users {
+		if user.ID == id {
+			return &user, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// List returns a copy of all users
+func (s *JSONFileUserStore) List() ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]User{}, s.users...), nil
+}
+
+// Update replaces the user and writes the file
+func (s *JSONFileUserStore) Update(user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := append([]User{}, s.users...)
+	for i := range users {
+		if users[i].ID == user.ID {
+			users[i] = user
+			return s.write(users)
+		}
+	}
+	return os.ErrNotExist
+}
+
+// Reload reads the file again and replaces the users, if the file is valid
+func (s *JSONFileUserStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.filename)
+	if err != nil {
+		return err
+	}
+	users, err := loadUsersFromJSON(s.filename)
+	if err != nil {
+		return err
+	}
+
+	added, removed, changed := diffUsers(s.users, users)
+	s.users = users
+	s.modified = info.ModTime()
+	s.size = info.Size()
+
+	logger := loggerOrDefault(s.Logger)
+	logger.Info("loaded users", "file", s.filename, "count", len(users),
+		"added", added, "removed", removed, "changed", changed)
+	for _, user := range users {
+		if user.Password != "" && !user.PasswordHashed() {
+			logger.Warn("plaintext password, change it to store a hash", "file", s.filename, "user", user.Name)
+		}
+	}
+
+	return nil
+}
+
+// Watch polls the file in the interval and reloads it when it was modified, until
+// stop is closed. Errors are logged, the previous users stay in place.
+func (s *JSONFileUserStore) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !s.modifiedSinceLoad() {
+				continue
+			}
+			if err := s.Reload(); err != nil {
+				loggerOrDefault(s.Logger).Warn("reload users failed, keeping previous", "file", s.filename, "error", err)
+
+				// don't retry the same broken file on every tick
+				s.markSeen()
+			}
+		}
+	}
+}
+
+// write validates and writes the users, then replaces the loaded users. Must be
+// called with the lock held.
+func (s *JSONFileUserStore) write(users []User) error {
+	if err := validateUsers(users); err != nil {
+		return err
+	}
+	encoded, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write into a temporary file first, so a crash can't leave a half written file
+	tmp := s.filename + ".tmp"
+	if err = ioutil.WriteFile(tmp, encoded, 0600); err != nil {
+		return err
+	} else if err = os.Rename(tmp, s.filename); err != nil {
+		return err
+	}
+
+	s.users = users
+	if info, err := os.Stat(s.filename); err == nil {
+		s.modified = info.ModTime()
+		s.size = info.Size()
+	}
+	return nil
+}
+
+func (s *JSONFileUserStore) modifiedSinceLoad() bool {
+	info, err := os.Stat(s.filename)
+	if err != nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !info.ModTime().Equal(s.modified) || info.Size() != s.size
+}
+
+func (s *JSONFileUserStore) markSeen() {
+	info, err := os.Stat(s.filename)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modified = info.ModTime()
+	s.size = info.Size()
+}
+
+// diffUsers returns the names of users which were added, removed or changed
+func diffUsers(previous, current []User) (added, removed, changed []string) {
+	before := make(map[string]User)
+	for _, user := range previous {
+		before[user.ID] = user
+	}
+	added, removed, changed = []string{}, []string{}, []string{}
+	for _, user := range current {
+		if old, ok := before[user.ID]; !ok {
+			added = append(added, user.Name)
+		} else if old != user {
+			changed = append(changed, user.Name)
+		}
+		delete(before, user.ID)
+	}
+	for _, user := range before {
+		removed = append(removed, user.Name)
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return
+}
+
+// SQLUserStore implements UserStore with a database table, which can be created
+// with CreateTable. Queries use "?" placeholders, as understood by SQLite and MySQL
+// drivers.
+type SQLUserStore struct {
+	DB *sql.DB
+
+	// Table is the name of the users table, defaults to "users"
+	Table string
+}
+
+// CreateTable creates the users table, if it does not exist
+func (s SQLUserStore) CreateTable() error {
+	_, err := s.DB.Exec(`CREATE TABLE IF NOT EXISTS ` + s.table() + ` (
+		id VARCHAR(64) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL UNIQUE,
+		pass VARCHAR(255) NOT NULL,
+		admin BOOLEAN NOT NULL DEFAULT FALSE
+	)`)
+	return err
+}
+
+// Create inserts the user
+func (s SQLUserStore) Create(user User) (string, error) {
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	if err := validateUsers([]User{user}); err != nil {
+		return "", err
+	} else if _, err = FindUserByName(s, user.Name); err == nil {
+		return "", fmt.Errorf("duplicate name %s: %w", user.Name, DuplicateUserError)
+	}
+	_, err := s.DB.Exec(`INSERT INTO `+s.table()+` (id, name, pass, admin) VALUES (?, ?, ?, ?)`,
+		user.ID, user.Name, user.Password, user.Admin)
+	if err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
+
+// Delete removes the user row
+func (s SQLUserStore) Delete(id string) error {
+	res, err := s.DB.Exec(`DELETE FROM `+s.table()+` WHERE id = ?`, id)
+	return s.affected(res, err)
+}
+
+// Get selects the user row
+func (s SQLUserStore) Get(id string) (*User, error) {
+	var user User
+	err := s.DB.QueryRow(`SELECT id, name, pass, admin FROM `+s.table()+` WHERE id = ?`, id).
+		Scan(&user.ID, &user.Name, &user.Password, &user.Admin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// List selects all user rows
+func (s SQLUserStore) List() ([]User, error) {
+	rows, err := s.DB.Query(`SELECT id, name, pass, admin FROM ` + s.table() + ` ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var user User
+		if err = rows.Scan(&user.ID, &user.Name, &user.Password, &user.Admin); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Update replaces the user row
+func (s SQLUserStore) Update(user User) error {
+	if err := validateUsers([]User{user}); err != nil {
+		return err
+	} else if other, err := FindUserByName(s, user.Name); err == nil && other.ID != user.ID {
+		return fmt.Errorf("duplicate name %s: %w", user.Name, DuplicateUserError)
+	}
+	res, err := s.DB.Exec(`UPDATE `+s.table()+` SET name = ?, pass = ?, admin = ? WHERE id = ?`,
+		user.Name, user.Password, user.Admin, user.ID)
+	return s.affected(res, err)
+}
+
+func (s SQLUserStore) affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+func (s SQLUserStore) table() string {
+	if s.Table == "" {
+		return "users"
+	}
+	return s.Table
+}
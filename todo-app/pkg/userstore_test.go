@@ -0,0 +1,179 @@
+package todo_test
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	todo "github.com/ukautz/go-intro/todo-app/pkg"
+	_ "modernc.org/sqlite"
+)
+
+func TestJSONFileUserStore(t *testing.T) {
+	path, cleanup := createTestUsersFile(t, `[{"id":"u01","name":"alice","pass":"secret1","admin":true}]`)
+	defer cleanup()
+
+	store, err := todo.NewJSONFileUserStore(path)
+	require.NoError(t, err)
+
+	id, err := store.Create(todo.User{Name: "bob", Password: "secret2"})
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+
+	_, err = store.Create(todo.User{Name: "bob", Password: "other"})
+	assert.True(t, errors.Is(err, todo.DuplicateUserError))
+
+	user, err := store.Get(id)
+	require.NoError(t, err)
+	user.Password = "changed"
+	require.NoError(t, store.Update(*user))
+
+	// changes are written to the file
+	reloaded, err := todo.NewJSONFileUserStore(path)
+	require.NoError(t, err)
+	users, err := reloaded.List()
+	require.NoError(t, err)
+	assert.Equal(t, []todo.User{
+		{ID: "u01", Name: "alice", Password: "secret1", Admin: true},
+		{ID: id, Name: "bob", Password: "changed"},
+	}, users)
+
+	require.NoError(t, reloaded.Delete(id))
+	_, err = reloaded.Get(id)
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, os.IsNotExist(reloaded.Delete(id)))
+	assert.True(t, os.IsNotExist(reloaded.Update(todo.User{ID: id, Name: "bob", Password: "x"})))
+}
+
+func TestSQLUserStore(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	// each connection has its own in-memory database
+	db.SetMaxOpenConns(1)
+
+	store := todo.SQLUserStore{DB: db}
+	require.NoError(t, store.CreateTable())
+	require.NoError(t, store.CreateTable(), "creating an existing table is fine")
+
+	id, err := store.Create(todo.User{Name: "alice", Password: "secret1", Admin: true})
+	require.NoError(t, err)
+	assert.NotEmpty(t, id)
+	_, err = store.Create(todo.User{ID: "u02", Name: "bob", Password: "secret2"})
+	require.NoError(t, err)
+	_, err = store.Create(todo.User{Name: "alice", Password: "other"})
+	assert.True(t, errors.Is(err, todo.DuplicateUserError))
+	_, err = store.Create(todo.User{Name: "", Password: "other"})
+	assert.Error(t, err)
+
+	user, err := store.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, todo.User{ID: id, Name: "alice", Password: "secret1", Admin: true}, *user)
+	_, err = store.Get("unknown")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	user.Name = "bob"
+	assert.True(t, errors.Is(store.Update(*user), todo.DuplicateUserError))
+	user.Name = "carol"
+	user.Admin = false
+	require.NoError(t, store.Update(*user))
+	assert.True(t, errors.Is(store.Update(todo.User{ID: "unknown", Name: "dave"}), os.ErrNotExist))
+
+	users, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []todo.User{
+		{ID: "u02", Name: "bob", Password: "secret2"},
+		{ID: id, Name: "carol", Password: "secret1"},
+	}, users)
+
+	require.NoError(t, store.Delete("u02"))
+	assert.True(t, errors.Is(store.Delete("u02"), os.ErrNotExist))
+	users, err = store.List()
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+}
+
+func TestJSONFileUserStore_Reload(t *testing.T) {
+	path, cleanup := createTestUsersFile(t, `[{"id":"u01","name":"alice","pass":"secret1"}]`)
+	defer cleanup()
+
+	store, err := todo.NewJSONFileUserStore(path)
+	require.NoError(t, err)
+	auth := todo.StoreAuthentication{Store: store}
+
+	_, err = auth.Authenticate(createBasicAuthTestRequest("bob", "secret2"))
+	assert.Error(t, err)
+
+	// valid file replaces users
+	writeTestUsersFile(t, path, `[{"id":"u01","name":"alice","pass":"secret1"},{"id":"u02","name":"bob","pass":"secret2"}]`)
+	require.NoError(t, store.Reload())
+	userID, err := auth.Authenticate(createBasicAuthTestRequest("bob", "secret2"))
+	require.NoError(t, err)
+	assert.Equal(t, "u02", userID)
+
+	// broken files keep previous users
+	for name, content := range map[string]string{
+		"syntax":       `[{"id":"u01"`,
+		"duplicate id": `[{"id":"u01","name":"alice","pass":"a"},{"id":"u01","name":"bob","pass":"b"}]`,
+		"missing name": `[{"id":"u01","pass":"a"}]`,
+	} {
+		writeTestUsersFile(t, path, content)
+		assert.Error(t, store.Reload(), name)
+		users, err := store.List()
+		require.NoError(t, err)
+		assert.Len(t, users, 2, name)
+	}
+
+	// reloads are logged with the injected logger
+	out := new(bytes.Buffer)
+	store.Logger = slog.New(slog.NewJSONHandler(out, nil))
+	writeTestUsersFile(t, path, `[{"id":"u01","name":"alice","pass":"secret1"}]`)
+	require.NoError(t, store.Reload())
+	assert.Contains(t, out.String(), `"msg":"loaded users","file":"`+path+`","count":1,"added":[],"removed":["bob"],"changed":[]}`)
+	assert.Contains(t, out.String(), `"msg":"plaintext password, change it to store a hash","file":"`+path+`","user":"alice"}`)
+}
+
+func TestJSONFileUserStore_Watch(t *testing.T) {
+	path, cleanup := createTestUsersFile(t, `[{"id":"u01","name":"alice","pass":"secret1"}]`)
+	defer cleanup()
+
+	store, err := todo.NewJSONFileUserStore(path)
+	require.NoError(t, err)
+	auth := todo.StoreAuthentication{Store: store}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go store.Watch(10*time.Millisecond, stop)
+
+	writeTestUsersFile(t, path, `[{"id":"u02","name":"bob","pass":"secret2"}]`)
+	assert.Eventually(t, func() bool {
+		userID, err := auth.Authenticate(createBasicAuthTestRequest("bob", "secret2"))
+		return err == nil && userID == "u02"
+	}, time.Second, 10*time.Millisecond)
+}
+
+// createTestUsersFile writes a users file into a temporary directory and returns
+// it's path and a function removing it
+func createTestUsersFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "users")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "users.json")
+	writeTestUsersFile(t, path, content)
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func writeTestUsersFile(t *testing.T, path, content string) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	// assure a changed modification time, even on coarse file systems
+	modified := time.Now().Add(time.Duration(len(content)) * time.Second)
+	require.NoError(t, os.Chtimes(path, modified, modified))
+}